@@ -1,10 +1,14 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"path"
+	"strings"
 
 	"dennis-tra/image-stego/internal/chunk"
 )
@@ -13,37 +17,170 @@ func main() {
 
 	decodePtr := flag.Bool("d", false, "Whether to decode the given image file(s)")
 	encodePtr := flag.Bool("e", false, "Whether to encode the given image file(s)")
-	outputPtr := flag.String("o", "", "Output directory of an encoded image")
+	keygenPtr := flag.Bool("keygen", false, "Whether to generate an Ed25519 key pair for signing, named after the given name(s) (default \"stego\")")
+	outputPtr := flag.String("o", "", "Output directory of an encoded image or a generated key pair")
+	depthPtr := flag.Int("depth", 1, "Number of low bits (1-4) of each color channel, or (1-2) of each DCT coefficient with -jpeg, that carry the encoded data")
+	keyPtr := flag.String("key", "", "Secret key that determines the positions of the encoded data (required for decoding if used for encoding)")
+	hmacKeyPtr := flag.String("hmac-key", "", "Shared secret that turns the chunk and Merkle tree hashes into HMACs, so only its holders can produce or verify valid chunks (required for decoding if used for encoding)")
+	channelsPtr := flag.String("channels", "rgb", "Color channels that carry the encoded data, e.g. b, rgb or rgba")
+	jpegPtr := flag.Bool("jpeg", false, "Whether to encode the data into the DCT coefficients of a JPEG image instead of the LSBs of a PNG image, the data doesn't survive saving the JPEG again")
+	hashPtr := flag.String("hash", "sha256", "Hash algorithm of the Merkle tree, one of "+strings.Join(chunk.HashAlgorithmNames(), ", "))
+	truncatePtr := flag.Int("truncate", 0, "Number of bits (multiple of 8, e.g. 64 or 128) the Merkle proof hashes in each chunk are truncated to for more and smaller chunks at a lower security level, 0 keeps the full hashes")
+	qualityPtr := flag.Int("quality", 90, "JPEG quality (1-100) of an image encoded with -jpeg")
+	rootPtr := flag.String("root", "", "Trusted hex encoded Merkle root to verify the decoded image(s) against instead of the root most chunks agree on")
+	rootFilePtr := flag.String("root-file", "", "File that contains the trusted hex encoded Merkle root (see -root)")
+	signPtr := flag.String("sign", "", "Private key file (see -keygen) to sign the Merkle root of the encoded image(s) with")
+	trustedKeysPtr := flag.String("trusted-keys", "", "File with the public keys (see -keygen) of the signers whose signatures are trusted when decoding, one per line")
+	subBlocksPtr := flag.Int("sub-blocks", 0, "Number of sub-blocks along each side of a chunk whose hashes are embedded in its spare capacity to localise changes within a tampered chunk, 0 disables them")
+	levelsPtr := flag.Int("levels", 1, "Number of levels of a quadtree layout of the chunks, every coarser level merges 2x2 chunks and carries its data in the next higher bit (times -depth), 1 lays out a single grid")
+	gridPtr := flag.String("grid", "", "Fixed grid of chunks of the finest level as columns x rows, e.g. 8x4, instead of the finest grid whose chunks just fit their proofs")
+	chunkSizePtr := flag.String("chunk-size", "", "Fixed size of the chunks of the finest level in pixels as width x height, e.g. 64x64, chunks at the edges are clipped")
+	formatPtr := flag.String("format", "text", "Output format of the verification results, text (log output) or json (printed to stdout)")
 
 	flag.Parse()
 
 	cwd, err := os.Getwd()
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		os.Exit(exitFailure)
 	}
 
-	if _, err := os.Stat(path.Join(cwd, *outputPtr)); *encodePtr && os.IsNotExist(err) {
+	if _, err := os.Stat(path.Join(cwd, *outputPtr)); (*encodePtr || *keygenPtr) && os.IsNotExist(err) {
 		log.Println("Output directory does not exist")
 		flag.PrintDefaults()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
-	if *decodePtr == *encodePtr {
-		log.Println("Incompatible combination of decode and encode flags")
-		log.Println("Please specify weather you want to encode -e or decode -d the image file(s)")
+	modes := 0
+	for _, mode := range []bool{*decodePtr, *encodePtr, *keygenPtr} {
+		if mode {
+			modes++
+		}
+	}
+	if modes != 1 {
+		log.Println("Incompatible combination of decode, encode and keygen flags")
+		log.Println("Please specify weather you want to encode -e or decode -d the image file(s) or generate a key pair -keygen")
+		flag.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	if *keygenPtr {
+		names := flag.Args()
+		if len(names) == 0 {
+			names = []string{"stego"}
+		}
+		for _, name := range names {
+			pubFilepath, err := chunk.GenerateKeyPair(*outputPtr, name)
+			if err != nil {
+				log.Println(err)
+				os.Exit(exitFailure)
+			}
+			log.Println("Generated key pair, add the public key to the trusted keys of the verifiers:", pubFilepath)
+		}
+		return
+	}
+
+	opts := chunk.DefaultOptions()
+	opts.Depth = *depthPtr
+	opts.Key = []byte(*keyPtr)
+	opts.MACKey = []byte(*hmacKeyPtr)
+	opts.DCT = *jpegPtr
+	opts.Quality = *qualityPtr
+	opts.ProofHashBits = *truncatePtr
+	opts.SubBlocks = *subBlocksPtr
+	opts.Levels = *levelsPtr
+	opts.Channels, err = chunk.ParseChannels(*channelsPtr)
+	if err == nil {
+		opts.Hash, err = chunk.ParseHashAlgorithm(*hashPtr)
+	}
+	if err == nil && *gridPtr != "" {
+		opts.Columns, opts.Rows, err = chunk.ParseSize(*gridPtr)
+	}
+	if err == nil && *chunkSizePtr != "" {
+		opts.ChunkWidth, opts.ChunkHeight, err = chunk.ParseSize(*chunkSizePtr)
+	}
+	if err == nil && *signPtr != "" {
+		opts.SigningKey, err = chunk.ReadSigningKey(*signPtr)
+	}
+	if err == nil {
+		err = opts.Validate()
+	}
+	if err != nil {
+		log.Println(err)
+		flag.PrintDefaults()
+		os.Exit(exitUsage)
+	}
+
+	dopts := chunk.DecodeOptions{Key: []byte(*keyPtr), MACKey: []byte(*hmacKeyPtr), Logger: log.New(os.Stderr, "", log.LstdFlags)}
+	if *rootPtr != "" && *rootFilePtr != "" {
+		err = errors.New("please specify the trusted root either with -root or with -root-file")
+	} else if *rootPtr != "" {
+		dopts.Root, err = chunk.ParseRoot(*rootPtr)
+	} else if *rootFilePtr != "" {
+		dopts.Root, err = chunk.ReadRootFile(*rootFilePtr)
+	}
+	if err == nil && *trustedKeysPtr != "" {
+		dopts.TrustedKeys, err = chunk.ReadTrustedKeys(*trustedKeysPtr)
+	}
+	if err == nil && *formatPtr != "text" && *formatPtr != "json" {
+		err = fmt.Errorf("unknown output format %q", *formatPtr)
+	}
+	if err != nil {
+		log.Println(err)
 		flag.PrintDefaults()
-		os.Exit(1)
+		os.Exit(exitUsage)
 	}
 
+	results := []result{}
 	for _, filename := range flag.Args() {
 
+		var res result
 		if *decodePtr {
-			err = chunk.Decode(filename)
-		} else if *encodePtr {
-			err = chunk.Encode(filename, *outputPtr)
+			res = decode(filename, dopts, *formatPtr == "text")
+		} else if err = chunk.Encode(filename, *outputPtr, opts); err != nil {
+			res = newErrorResult(filename, err)
+		}
+
+		if res.Error != "" {
+			log.Println(res.Error)
 		}
-		if err != nil {
+		results = append(results, res)
+	}
+
+	if *decodePtr && *formatPtr == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(results); err != nil {
 			log.Println(err)
+			os.Exit(exitFailure)
 		}
 	}
+
+	os.Exit(batchExitCode(results))
+}
+
+// decode verifies the given encoded image file and saves the overlay image of a tampered image next to it.
+// The outcome is logged if logReport is set.
+func decode(filename string, dopts chunk.DecodeOptions, logReport bool) result {
+	report, err := chunk.Decode(filename, dopts)
+	if err != nil {
+		return newErrorResult(filename, err)
+	}
+
+	if logReport {
+		report.Log()
+	}
+
+	res := newResult(filename, report)
+	if report.Overlay == nil {
+		return res
+	}
+
+	res.Overlay = path.Join(path.Dir(filename), chunk.SetExtension(path.Base(filename), ".overlay.png"))
+	log.Println("Saving overlay image:", res.Overlay)
+	if err = chunk.SaveImageFile(res.Overlay, report.Overlay); err != nil {
+		return newErrorResult(filename, err)
+	}
+
+	return res
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/hex"
+	"image"
+
+	"dennis-tra/image-stego/internal/chunk"
+)
+
+// The exit codes of the command. If several files are given the most severe outcome determines the code
+// (see exitSeverity).
+const (
+	exitClean        = 0
+	exitTampered     = 1
+	exitUsage        = 2
+	exitNotEncoded   = 3
+	exitFailure      = 4
+	exitNoCommonRoot = 5
+)
+
+// exitSeverity orders the exit codes of the verification outcomes from the least to the most severe one. Files
+// that couldn't be verified rank highest, followed by images whose chunks don't agree on any root, as their
+// verification failed too. A tampered image ranks above an image that hasn't been encoded at all.
+var exitSeverity = []int{exitClean, exitNotEncoded, exitTampered, exitNoCommonRoot, exitFailure}
+
+// batchExitCode returns the exit code of the most severe outcome of the given results (see exitSeverity).
+func batchExitCode(results []result) int {
+	exitCode, worst := exitClean, 0
+	for _, res := range results {
+		for severity, code := range exitSeverity {
+			if code == res.exitCode && severity > worst {
+				exitCode, worst = code, severity
+			}
+		}
+	}
+	return exitCode
+}
+
+// result is the machine-readable outcome of the verification of a single file.
+type result struct {
+	File      string            `json:"file"`
+	Status    string            `json:"status"`
+	Root      string            `json:"root,omitempty"`
+	Trusted   bool              `json:"trusted"`
+	Chunks    int               `json:"chunks"`
+	Tampered  []chunkResult     `json:"tampered"`
+	Reasons   map[string]int    `json:"reasons"`
+	Tampering map[string]int    `json:"tampering"`
+	Roots     []rootCountResult `json:"roots"`
+	Signature *signatureResult  `json:"signature,omitempty"`
+	Overlay   string            `json:"overlay,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	exitCode  int
+}
+
+// chunkResult is a chunk that doesn't lead to the Merkle root. Its status is the reason, its tamper the kind
+// of manipulation.
+type chunkResult struct {
+	Index     int          `json:"index"`
+	Level     int          `json:"level"`
+	Column    int          `json:"column"`
+	Row       int          `json:"row"`
+	Bounds    rectResult   `json:"bounds"`
+	Status    string       `json:"status"`
+	Tamper    string       `json:"tamper"`
+	MovedFrom *int         `json:"moved_from,omitempty"`
+	Changed   []rectResult `json:"changed,omitempty"`
+	Root      string       `json:"root,omitempty"`
+}
+
+// rectResult is the pixel rectangle of a chunk or a sub-block.
+type rectResult struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// rootCountResult is the number of chunks that lead to a Merkle root.
+type rootCountResult struct {
+	Root  string `json:"root"`
+	Count int    `json:"count"`
+}
+
+// signatureResult is the verification result of the signature of the Merkle root.
+type signatureResult struct {
+	Recovered bool   `json:"recovered"`
+	KeyID     string `json:"key_id,omitempty"`
+	Signer    string `json:"signer,omitempty"`
+	Verified  bool   `json:"verified"`
+	Required  bool   `json:"required"`
+}
+
+// newResult converts the report of the verification of the given file. The chunks of an image that
+// hasn't been encoded or whose chunks don't agree on any root are not listed as tampered.
+func newResult(filename string, report *chunk.Report) result {
+	r := result{
+		File:      filename,
+		Status:    report.Status.String(),
+		Root:      hex.EncodeToString(report.Root),
+		Trusted:   report.Trusted,
+		Chunks:    len(report.Chunks),
+		Tampered:  []chunkResult{},
+		Reasons:   map[string]int{},
+		Tampering: map[string]int{},
+		Roots:     []rootCountResult{},
+	}
+
+	// A root that no trusted key vouches for fails like a tampered image, as the image may have been
+	// encoded again after a manipulation
+	switch report.Status {
+	case chunk.StatusClean:
+		r.exitCode = exitClean
+	case chunk.StatusTampered, chunk.StatusNoTrustedMatch, chunk.StatusUntrustedSignature:
+		r.exitCode = exitTampered
+	case chunk.StatusNotEncoded:
+		r.exitCode = exitNotEncoded
+		return r
+	case chunk.StatusNoCommonRoot:
+		r.exitCode = exitNoCommonRoot
+		return r
+	default:
+		r.exitCode = exitFailure
+	}
+
+	for _, c := range report.Tampered() {
+		cr := chunkResult{
+			Index:  c.Index,
+			Level:  c.Level,
+			Column: c.Column,
+			Row:    c.Row,
+			Bounds: newRectResult(c.Bounds),
+			Status: c.Status.String(),
+			Tamper: c.Tamper.String(),
+			Root:   hex.EncodeToString(c.Root),
+		}
+		for _, changed := range c.Changed {
+			cr.Changed = append(cr.Changed, newRectResult(changed))
+		}
+		if c.Tamper == chunk.TamperMoved {
+			movedFrom := c.MovedFrom
+			cr.MovedFrom = &movedFrom
+		}
+		r.Tampered = append(r.Tampered, cr)
+		r.Reasons[c.Status.String()]++
+		r.Tampering[c.Tamper.String()]++
+	}
+
+	for _, rc := range report.Roots {
+		r.Roots = append(r.Roots, rootCountResult{Root: hex.EncodeToString(rc.Root), Count: rc.Count})
+	}
+
+	if s := report.Signature; s != nil {
+		r.Signature = &signatureResult{
+			Recovered: s.Recovered,
+			KeyID:     hex.EncodeToString(s.KeyID),
+			Signer:    s.Signer,
+			Verified:  s.Verified,
+			Required:  s.Required,
+		}
+	}
+
+	return r
+}
+
+// newErrorResult returns the result of a file that couldn't be verified.
+func newErrorResult(filename string, err error) result {
+	return result{
+		File:      filename,
+		Status:    "error",
+		Tampered:  []chunkResult{},
+		Reasons:   map[string]int{},
+		Tampering: map[string]int{},
+		Roots:     []rootCountResult{},
+		Error:     err.Error(),
+		exitCode:  exitFailure,
+	}
+}
+
+func newRectResult(r image.Rectangle) rectResult {
+	return rectResult{X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy()}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"image"
+	"testing"
+
+	"dennis-tra/image-stego/internal/chunk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// marshalResult returns the JSON object of the given result.
+func marshalResult(t *testing.T, r result) map[string]interface{} {
+	data, err := json.Marshal(r)
+	require.NoError(t, err)
+
+	var obj map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &obj))
+	return obj
+}
+
+func TestNewResult_Status(t *testing.T) {
+	root := []byte{0xab, 0xcd}
+	tampered := chunk.ChunkReport{
+		Index:  1,
+		Column: 1,
+		Bounds: image.Rect(10, 0, 20, 10),
+		Root:   []byte{0x01},
+		Status: chunk.ChunkRootMismatch,
+		Tamper: chunk.TamperContent,
+	}
+	valid := chunk.ChunkReport{Bounds: image.Rect(0, 0, 10, 10), Root: root}
+
+	tests := []struct {
+		status   chunk.Status
+		chunks   []chunk.ChunkReport
+		exitCode int
+		tampered int
+	}{
+		{chunk.StatusClean, []chunk.ChunkReport{valid, valid}, exitClean, 0},
+		{chunk.StatusTampered, []chunk.ChunkReport{valid, tampered}, exitTampered, 1},
+		{chunk.StatusNoTrustedMatch, []chunk.ChunkReport{tampered}, exitTampered, 1},
+		{chunk.StatusNotEncoded, []chunk.ChunkReport{tampered}, exitNotEncoded, 0},
+		{chunk.StatusUntrustedSignature, []chunk.ChunkReport{valid, valid}, exitTampered, 0},
+		{chunk.StatusNoCommonRoot, []chunk.ChunkReport{tampered, tampered}, exitNoCommonRoot, 0},
+	}
+
+	for _, tt := range tests {
+		report := &chunk.Report{Status: tt.status, Root: root, Chunks: tt.chunks}
+		r := newResult("car.png", report)
+		assert.Equal(t, tt.exitCode, r.exitCode, tt.status)
+
+		obj := marshalResult(t, r)
+		assert.Equal(t, "car.png", obj["file"], tt.status)
+		assert.Equal(t, tt.status.String(), obj["status"], tt.status)
+		assert.Equal(t, "abcd", obj["root"], tt.status)
+		assert.EqualValues(t, len(tt.chunks), obj["chunks"], tt.status)
+		assert.Len(t, obj["tampered"], tt.tampered, tt.status)
+		assert.Contains(t, obj, "reasons", tt.status)
+		assert.Contains(t, obj, "tampering", tt.status)
+		assert.Contains(t, obj, "roots", tt.status)
+		assert.NotContains(t, obj, "signature", tt.status)
+		assert.NotContains(t, obj, "error", tt.status)
+	}
+}
+
+func TestNewResult_Tampered(t *testing.T) {
+	report := &chunk.Report{
+		Status: chunk.StatusTampered,
+		Root:   []byte{0xab},
+		Chunks: []chunk.ChunkReport{
+			{Index: 0, Root: []byte{0xab}},
+			{Index: 1, Column: 1, Bounds: image.Rect(10, 0, 20, 10), Status: chunk.ChunkInvalidSide, Tamper: chunk.TamperMoved, MovedFrom: 3},
+		},
+		Roots: []chunk.RootCount{{Root: []byte{0xab}, Count: 1}},
+	}
+
+	obj := marshalResult(t, newResult("car.png", report))
+	assert.Equal(t, map[string]interface{}{"invalid side flag": 1.0}, obj["reasons"])
+	assert.Equal(t, map[string]interface{}{"moved": 1.0}, obj["tampering"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"root": "ab", "count": 1.0}}, obj["roots"])
+
+	require.Len(t, obj["tampered"], 1)
+	c := obj["tampered"].([]interface{})[0].(map[string]interface{})
+	assert.EqualValues(t, 1, c["index"])
+	assert.Equal(t, "invalid side flag", c["status"])
+	assert.Equal(t, "moved", c["tamper"])
+	assert.EqualValues(t, 3, c["moved_from"])
+	assert.Equal(t, map[string]interface{}{"x": 10.0, "y": 0.0, "width": 10.0, "height": 10.0}, c["bounds"])
+	assert.NotContains(t, c, "root")
+}
+
+func TestNewResult_Signature(t *testing.T) {
+	for _, status := range []chunk.Status{chunk.StatusClean, chunk.StatusUntrustedSignature, chunk.StatusTampered} {
+		report := &chunk.Report{
+			Status:    status,
+			Signature: &chunk.SignatureReport{Recovered: true, KeyID: []byte{0x12}, Signer: "alice", Required: true},
+		}
+		r := newResult("car.png", report)
+
+		obj := marshalResult(t, r)
+		assert.Equal(t, map[string]interface{}{
+			"recovered": true,
+			"key_id":    "12",
+			"signer":    "alice",
+			"verified":  false,
+			"required":  true,
+		}, obj["signature"], status)
+	}
+
+	// A signature that no trusted key made fails regardless of the chunks
+	r := newResult("car.png", &chunk.Report{Status: chunk.StatusUntrustedSignature, Signature: &chunk.SignatureReport{Required: true}})
+	assert.NotEqual(t, exitClean, r.exitCode)
+}
+
+func TestNewErrorResult(t *testing.T) {
+	r := newErrorResult("car.png", errors.New("broken"))
+	assert.Equal(t, exitFailure, r.exitCode)
+
+	obj := marshalResult(t, r)
+	assert.Equal(t, "error", obj["status"])
+	assert.Equal(t, "broken", obj["error"])
+	assert.NotContains(t, obj, "root")
+	assert.Empty(t, obj["tampered"])
+}
+
+func TestBatchExitCode(t *testing.T) {
+	resultOf := func(status chunk.Status) result {
+		return newResult("car.png", &chunk.Report{Status: status})
+	}
+	clean := resultOf(chunk.StatusClean)
+	tampered := resultOf(chunk.StatusTampered)
+	notEncoded := resultOf(chunk.StatusNotEncoded)
+	noCommonRoot := resultOf(chunk.StatusNoCommonRoot)
+	failed := newErrorResult("car.png", errors.New("broken"))
+
+	tests := []struct {
+		results  []result
+		exitCode int
+	}{
+		{[]result{}, exitClean},
+		{[]result{clean, clean}, exitClean},
+		{[]result{clean, notEncoded}, exitNotEncoded},
+		{[]result{tampered, notEncoded}, exitTampered},
+		{[]result{notEncoded, tampered, clean}, exitTampered},
+		{[]result{tampered, noCommonRoot, notEncoded}, exitNoCommonRoot},
+		{[]result{failed, noCommonRoot, tampered}, exitFailure},
+		{[]result{notEncoded, failed}, exitFailure},
+	}
+
+	for i, tt := range tests {
+		assert.Equal(t, tt.exitCode, batchExitCode(tt.results), i)
+	}
+}
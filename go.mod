@@ -3,7 +3,6 @@ module dennis-tra/image-stego
 go 1.14
 
 require (
-	github.com/cbergoon/merkletree v0.2.0
-	github.com/icza/bitio v1.0.0
 	github.com/stretchr/testify v1.6.1
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 )
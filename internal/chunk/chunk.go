@@ -1,28 +1,70 @@
 package chunk
 
 import (
-	"bytes"
-	"crypto/sha256"
-	"errors"
+	"encoding/binary"
 	"image"
 	"io"
 
 	"dennis-tra/image-stego/pkg/bit"
-
-	"github.com/cbergoon/merkletree"
-	"github.com/icza/bitio"
+	"dennis-tra/image-stego/pkg/merkle"
 )
 
-// Chunk is a wrapper around an image.RGBA struct that keeps track of
-// the read and written bytes to the least significant bits of the underlying *image.RGBA.
+// Chunk is a wrapper around an Image that keeps track of the read and written bits to the
+// least significant bits of the underlying image. The image is either an *image.NRGBA or,
+// for 16-bit images, an *image.NRGBA64. In both cases the color values are not premultiplied
+// by the alpha value so that they are not altered by the embedding of the payload.
+// Grayscale images are wrapped as *image.Gray or *image.Gray16 and carry the payload in
+// their single gray value, indexed images as *image.Paletted and carry the payload in their
+// palette index. Images that are embedded in the DCT domain are wrapped as *jpegdct.Image, whose
+// pixels are 8x8 blocks that carry the payload in mid-frequency luminance coefficients. The underlying
+// image may be a sub image of a larger one.
 type Chunk struct {
-	*image.RGBA
+	Image
 
-	// The number of read bytes. Subsequent calls to read will continue where the last read left off.
+	// The number of read bits. Subsequent calls to read will continue where the last read left off.
 	rOff int
 
-	// The number of written bytes. Subsequent calls to write will continue where the last write left off.
+	// The number of written bits. Subsequent calls to write will continue where the last write left off.
 	wOff int
+
+	// Channels selects the color channels whose least significant bits carry the payload.
+	// If no channels are set the R, G and B channels are used. Grayscale images ignore it.
+	Channels Channel
+
+	// Depth is the number of low bits of each payload channel that carry the payload.
+	// If no depth is set only the least significant bit is used.
+	Depth int
+
+	// Planes is the number of groups of Depth low bits of each payload channel that carry payload, one
+	// for every level of chunks (see Options.Levels). All of them are excluded from the hash of the chunk.
+	// If no number is set there is a single group.
+	Planes int
+
+	// Plane is the group of Depth low bits, counted from the least significant one, that carries the
+	// payload of this chunk.
+	Plane int
+
+	// Index is the position of the chunk in the list of all chunks of the image.
+	Index int
+
+	// ImageSize is the size in pixels of the whole image the chunk is part of. If it is set, the hash
+	// of the chunk commits to the position of the chunk: its index, its pixel bounds and the image size.
+	// So a chunk that is moved to another position or transplanted into another image doesn't verify
+	// although its content and payload are intact.
+	ImageSize image.Point
+
+	// Hash is the hash algorithm of the chunk hash. If no algorithm is set SHA-256 is used.
+	Hash HashAlgorithm
+
+	// MACKey turns the hash of the chunk into an HMAC with this key (see Options.MACKey).
+	MACKey []byte
+
+	// Key is the secret key that determines the positions of the payload bits within the chunk.
+	// If no key is set the payload bits are placed sequentially starting at the top left pixel.
+	Key []byte
+
+	// The keyed permutation of the LSB positions. It is created on first use.
+	perm *permutation
 }
 
 // MaxPayloadSize returns the maximum number of bytes that can be written to this chunk
@@ -42,13 +84,56 @@ func (c *Chunk) Height() int {
 
 // PixelCount returns the total number of pixels
 func (c *Chunk) PixelCount() int {
-	return len(c.Pix) / 4
+	return c.Width() * c.Height()
 }
 
 // LSBCount returns the total number of least significant bits (LSB) available for encoding a message.
-// Currently only the RGB values are considered not the A.
+// Only the Depth low bits of the selected channels are considered.
 func (c *Chunk) LSBCount() int {
-	return c.PixelCount() * BitsPerPixel
+	return c.PixelCount() * len(c.payloadOffsets()) * c.depth()
+}
+
+// payloadOffsets returns the offsets of the values within a pixel that carry payload. For grayscale
+// images this is the single gray value, for DCT coefficient images the mid-frequency luminance
+// coefficients of a block and for color images the values of the selected channels.
+func (c *Chunk) payloadOffsets() []int {
+	if _, format := pixelsOf(c.Image); format.payload != nil {
+		return format.payload
+	}
+	return c.channels().Offsets()
+}
+
+// hash returns the hash algorithm or the default algorithm if none is set.
+func (c *Chunk) hash() HashAlgorithm {
+	if c.Hash == 0 {
+		return DefaultOptions().Hash
+	}
+	return c.Hash
+}
+
+// channels returns the selected payload channels or the default channels if none are set.
+func (c *Chunk) channels() Channel {
+	if c.Channels == 0 {
+		return DefaultOptions().Channels
+	}
+	return c.Channels
+}
+
+// depth returns the embedding depth or the default depth if none is set.
+func (c *Chunk) depth() int {
+	if c.Depth == 0 {
+		return DefaultOptions().Depth
+	}
+	return c.Depth
+}
+
+// maskedDepth returns the number of low bits of each payload channel that carry payload of any level and
+// are therefore excluded from the hash.
+func (c *Chunk) maskedDepth() int {
+	if c.Planes == 0 {
+		return c.depth()
+	}
+	return c.Planes * c.depth()
 }
 
 // MinX in this context returns the starting value for iterating over the horizontal axis of the image
@@ -71,27 +156,33 @@ func (c *Chunk) MaxY() int {
 	return c.Bounds().Max.Y
 }
 
-// CalculateHash calculates the SHA256 hash of the 7 most significant bits. The least
-// significant bit (LSB) is not considered in the hash generation as it is used to
-// store the (derived) Merkle leaves/nodes.
-// Note: From an implementation point of view the LSB is actually considered but
-// always overwritten by a 0.
-// This method (among Equal) lets Chunk conform to the merkletree.Content interface.
+// CalculateHash calculates the leaf hash of the chunk in the Merkle tree, an HMAC if a MAC key is set. The
+// Depth least significant bits (LSB) of the payload channels are not considered as they store the (derived)
+// Merkle leaves/nodes, all other bits including the alpha channel are. The position of the chunk, the palette
+// of indexed images and the quantization tables of DCT images are hashed as well.
+// Note: From an implementation point of view the LSBs are actually considered but
+// always overwritten by 0s.
 func (c *Chunk) CalculateHash() ([]byte, error) {
+	return c.calculateHashAt(c.Index, pixelRect(c.Image, c.Bounds()))
+}
 
-	h := sha256.New()
+// calculateHashAt calculates the hash of the chunk as if it had the given index and pixel bounds. It tells
+// whether the content of the chunk has been moved from another position.
+func (c *Chunk) calculateHashAt(index int, bounds image.Rectangle) ([]byte, error) {
 
-	for x := c.MinX(); x < c.MaxX(); x++ {
-		for y := c.MinY(); y < c.MaxY(); y++ {
+	h := merkle.Hasher{New: c.hash().newFunc(c.MACKey)}.NewLeaf()
 
-			rgba := c.RGBAAt(x, y)
+	if _, err := h.Write(c.positionAt(index, bounds)); err != nil {
+		return nil, err
+	}
 
-			byt := []byte{
-				bit.WithLSB(rgba.R, false),
-				bit.WithLSB(rgba.G, false),
-				bit.WithLSB(rgba.B, false),
-			}
-			if _, err := h.Write(byt); err != nil {
+	if _, err := h.Write(sharedContentOf(c.Image)); err != nil {
+		return nil, err
+	}
+
+	for x := c.MinX(); x < c.MaxX(); x++ {
+		for y := c.MinY(); y < c.MaxY(); y++ {
+			if _, err := h.Write(c.contentAt(x, y)); err != nil {
 				return nil, err
 			}
 		}
@@ -100,41 +191,57 @@ func (c *Chunk) CalculateHash() ([]byte, error) {
 	return h.Sum(nil), nil
 }
 
-// Write writes the given bytes to the least significant bits of the chunk.
-// It returns the number of bytes written from p and an error if one occurred.
-// Consult the io.Writer documentation for the intended behaviour of this function.
-// A byte from p is either written completely or not at all to the least significant bits.
-// Subsequent calls to write will continue were the last write left off.
-func (c *Chunk) Write(p []byte) (n int, err error) {
-	r := bitio.NewReader(bytes.NewBuffer(p))
-
-	defer func() { c.wOff += n }()
+// position returns the encoded position of the chunk that is hashed in front of its content: the index,
+// the pixel bounds and the image size, each value as a big endian uint32. It returns nil if no image
+// size is set.
+func (c *Chunk) position() []byte {
+	return c.positionAt(c.Index, pixelRect(c.Image, c.Bounds()))
+}
 
-	for i := 0; i < len(p); i++ {
+// positionAt returns the encoded position (see position) of a chunk with the given index and pixel bounds.
+func (c *Chunk) positionAt(index int, bounds image.Rectangle) []byte {
+	if c.ImageSize == (image.Point{}) {
+		return nil
+	}
 
-		bitOff := (c.wOff + i) * BitsPerByte
+	values := []int{index, bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Max.Y, c.ImageSize.X, c.ImageSize.Y}
 
-		// Stop early if there is not enough LSB space left
-		if bitOff+7 >= len(c.Pix)-len(c.Pix)/4 {
-			return n, io.EOF
-		}
+	position := make([]byte, 4*len(values))
+	for i, v := range values {
+		binary.BigEndian.PutUint32(position[4*i:], uint32(v))
+	}
+	return position
+}
 
-		// At this point we're sure that a whole byte can still be written
-		for j := 0; j < BitsPerByte; j++ {
+// contentAt returns the bytes of the values (R, G, B and A or gray) of the pixel at the given position
+// with the low bits of the payload values of all planes set to 0.
+func (c *Chunk) contentAt(x, y int) []byte {
+	depth := uint8(c.maskedDepth())
+	pix, format := pixelsOf(c.Image)
+
+	i := c.PixOffset(x, y)
+	content := make([]byte, format.channels*format.bytesPerValue)
+	copy(content, pix[i:])
+	for _, off := range c.payloadOffsets() {
+		k := (off+1)*format.bytesPerValue - 1
+		content[k] = bit.WithLowBits(content[k], depth, 0)
+	}
 
-			bitVal, err := r.ReadBool()
-			if err != nil {
-				return n, err
-			}
+	return content
+}
 
-			idx := bitOff + j + (bitOff+j)/BitsPerPixel
-			c.Pix[idx] = bit.WithLSB(c.Pix[idx], bitVal)
+// Write writes the given bytes to the least significant bits of the chunk.
+// It returns the number of bytes written from p and an error if one occurred.
+// Consult the io.Writer documentation for the intended behaviour of this function.
+// A byte from p is either written completely or not at all to the least significant bits.
+// Subsequent calls to write will continue were the last write left off.
+func (c *Chunk) Write(p []byte) (n int, err error) {
+	for _, b := range p {
+		if err = c.WriteBits(uint64(b), BitsPerByte); err != nil {
+			return n, err
 		}
-
-		// As one byte was written increment the counter
 		n += 1
 	}
-
 	return n, nil
 }
 
@@ -142,82 +249,107 @@ func (c *Chunk) Write(p []byte) (n int, err error) {
 // It returns the number of bytes read from the least significant bits and an error if one occurred.
 // p will contain the contents from the least significant bits after the call has finished.
 func (c *Chunk) Read(p []byte) (n int, err error) {
+	for i := range p {
+		v, err := c.ReadBits(BitsPerByte)
+		if err != nil {
+			return n, err
+		}
+		p[i] = byte(v)
+		n += 1
+	}
+	return n, nil
+}
 
-	b := bytes.NewBuffer(p)
-	w := bitio.NewWriter(b)
-
-	b.Reset()
-
-	defer func() {
-		w.Close()
-		c.rOff += n
-	}()
-
-	for i := 0; i < len(p); i++ {
+// WriteBits writes the n lowest bits of r to the least significant bits of the chunk,
+// starting with the most significant one of those n bits. The bits are either written
+// completely or not at all. If there is not enough LSB space left io.EOF is returned.
+// Subsequent calls to write will continue were the last write left off.
+func (c *Chunk) WriteBits(r uint64, n uint8) error {
+	if c.wOff+int(n) > c.LSBCount() {
+		return io.EOF
+	}
 
-		// calculate current read bit offset: static read offset from potential last run plus idx-var times bits in a byte
-		bitOff := (c.rOff + i) * BitsPerByte
+	for i := int(n) - 1; i >= 0; i-- {
+		idx, plane := c.pixIndex(c.wOff)
+		c.pix()[idx] = bit.WithBit(c.pix()[idx], plane, r&(1<<uint(i)) != 0)
+		c.wOff += 1
+	}
 
-		// Stop early if there are not enough LSBs left
-		if bitOff+BitsPerByte+(bitOff+BitsPerByte)/BitsPerPixel > len(c.Pix) {
-			return n, io.EOF
-		}
+	return nil
+}
 
-		// At this point we're sure that a whole byte can still be read
-		for j := 0; j < BitsPerByte; j++ {
+// WriteBool writes a single bit to the least significant bits of the chunk.
+func (c *Chunk) WriteBool(b bool) error {
+	var r uint64
+	if b {
+		r = 1
+	}
+	return c.WriteBits(r, 1)
+}
 
-			idx := bitOff + j + (bitOff+j)/BitsPerPixel
-			v := bit.GetLSB(c.Pix[idx])
+// ReadBits reads n bits from the least significant bits of the chunk and returns
+// them as the lowest bits of the result. The first read bit becomes the most significant
+// one. If there are not enough LSBs left io.EOF is returned and nothing is read.
+func (c *Chunk) ReadBits(n uint8) (uint64, error) {
+	if c.rOff+int(n) > c.LSBCount() {
+		return 0, io.EOF
+	}
 
-			err := w.WriteBool(v)
-			if err != nil {
-				return n, err
-			}
+	var r uint64
+	for i := 0; i < int(n); i++ {
+		r <<= 1
+		idx, plane := c.pixIndex(c.rOff)
+		if bit.GetBit(c.pix()[idx], plane) {
+			r |= 1
 		}
-
-		// As one whole byte was read increment the counter
-		n += 1
+		c.rOff += 1
 	}
 
-	return n, err
+	return r, nil
 }
 
-// Equals tests for equality of two Contents. It only considers the 7 most significant bits since the last bit contains
-// the hash data of the other chunks and doesn't count to the equality.
-func (c *Chunk) Equals(o merkletree.Content) (bool, error) {
-
-	oc, ok := o.(*Chunk) // other chunk
-	if !ok {
-		return false, errors.New("invalid type casting")
-	}
+// ReadBool reads a single bit from the least significant bits of the chunk.
+func (c *Chunk) ReadBool() (bool, error) {
+	r, err := c.ReadBits(1)
+	return r == 1, err
+}
 
-	if oc.Width() != c.Width() || oc.Height() != c.Height() {
-		return false, nil
+// pixIndex maps the given LSB offset to the index of the corresponding byte in Pix and the
+// position of the bit within that byte. If a key is set the offset is first mapped to a
+// pseudo-random position using the keyed permutation of the chunk. Only the values of the selected channels of each pixel
+// (or the gray value) carry payload bits. The pixels are traversed row by row. The Depth bits of the Plane of a value are
+// filled from the most significant one down to the least significant bit before continuing with the next value.
+// For 16-bit values only the bits of the lower byte are used.
+func (c *Chunk) pixIndex(bitOff int) (int, uint8) {
+	if len(c.Key) > 0 {
+		bitOff = c.permutation().At(bitOff)
 	}
 
-	for x := c.MinX(); x < c.MaxX(); x++ {
-		for y := c.MinY(); y < c.MaxY(); y++ {
-
-			thisColor := c.RGBAAt(x, y)
-			otherColor := oc.RGBAAt(x, y)
+	_, format := pixelsOf(c.Image)
+	offsets := c.payloadOffsets()
+	depth := c.depth()
 
-			if bit.WithLSB(thisColor.R, false) != bit.WithLSB(otherColor.R, false) {
-				return false, nil
-			}
+	valOff := bitOff / depth
+	plane := uint8(c.Plane*depth + depth - 1 - bitOff%depth)
 
-			if bit.WithLSB(thisColor.G, false) != bit.WithLSB(otherColor.G, false) {
-				return false, nil
-			}
+	pixel := valOff / len(offsets)
+	x := c.MinX() + pixel%c.Width()
+	y := c.MinY() + pixel/c.Width()
 
-			if bit.WithLSB(thisColor.B, false) != bit.WithLSB(otherColor.B, false) {
-				return false, nil
-			}
+	return c.PixOffset(x, y) + (offsets[valOff%len(offsets)]+1)*format.bytesPerValue - 1, plane
+}
 
-			if bit.WithLSB(thisColor.A, false) != bit.WithLSB(otherColor.A, false) {
-				return false, nil
-			}
-		}
+// permutation returns the keyed permutation of the LSB positions of this chunk. It is derived from the
+// key and the index of the chunk, so every chunk spreads its payload differently.
+func (c *Chunk) permutation() *permutation {
+	if c.perm == nil {
+		c.perm = newPermutation(c.Key, c.Index, c.LSBCount())
 	}
+	return c.perm
+}
 
-	return true, nil
+// pix returns the Pix slice of the underlying image.
+func (c *Chunk) pix() []uint8 {
+	pix, _ := pixelsOf(c.Image)
+	return pix
 }
@@ -5,11 +5,13 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"image"
+	"image/color"
 	"io"
 	"math/rand"
 	"testing"
 
 	"dennis-tra/image-stego/pkg/bit"
+	"dennis-tra/image-stego/pkg/jpegdct"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -20,39 +22,39 @@ const ones = 0b11111111
 // zeroes is a byte with all bits set to zero
 const zeroes = 0b00000000
 
-// blackImage creates an RGBA image with the given width and height
-// where all pixels are black. The underlying Pix byte array
+// blackImage creates an NRGBA image with the given width and height
+// where all pixels are transparent black. The underlying Pix byte array
 // contains w x h x 4 entries.
-func blackImage(w, h int) *image.RGBA {
-	return image.NewRGBA(image.Rect(0, 0, w, h))
+func blackImage(w, h int) *image.NRGBA {
+	return image.NewNRGBA(image.Rect(0, 0, w, h))
 }
 
-// whiteImage creates an RGBA image with the given width and height
+// whiteImage creates an NRGBA image with the given width and height
 // where all pixels are white. The underlying Pix byte array
 // contains w x h x 4 entries.
-func whiteImage(w, h int) *image.RGBA {
-	img := image.NewRGBA(image.Rect(0, 0, w, h))
+func whiteImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
 	for i := range img.Pix {
 		img.Pix[i] = ones
 	}
 	return img
 }
 
-func TestBitsPerPixelInRange(t *testing.T) {
-	assert.GreaterOrEqual(t, BitsPerPixel, 1)
-	assert.LessOrEqual(t, BitsPerPixel, 4)
+func TestDefaultChannelsInRange(t *testing.T) {
+	assert.GreaterOrEqual(t, DefaultOptions().Channels.Count(), 1)
+	assert.LessOrEqual(t, DefaultOptions().Channels.Count(), 4)
 }
 
 func TestChunk_PixelCount(t *testing.T) {
 	width := rand.Int() % 100
 	height := rand.Int() % 100
-	chunk := Chunk{RGBA: blackImage(width, height)}
+	chunk := Chunk{Image: blackImage(width, height)}
 	assert.Equal(t, width*height, chunk.PixelCount())
 }
 
 func TestChunk_LSBCount(t *testing.T) {
-	chunk := Chunk{RGBA: blackImage(5, 5)}
-	assert.Equal(t, 5*5*BitsPerPixel, chunk.LSBCount())
+	chunk := Chunk{Image: blackImage(5, 5)}
+	assert.Equal(t, 5*5*ChannelsRGB.Count(), chunk.LSBCount())
 }
 
 func TestChunk_MaxPayloadSize1(t *testing.T) {
@@ -65,10 +67,10 @@ func TestChunk_MaxPayloadSize1(t *testing.T) {
 		{100, 100},
 	}
 	for _, tt := range tests {
-		want := tt.width * tt.height * BitsPerPixel / 8
+		want := tt.width * tt.height * ChannelsRGB.Count() / 8
 		name := fmt.Sprintf("An image of size %d x %d can hold %d bytes", tt.width, tt.height, want)
 		t.Run(name, func(t *testing.T) {
-			c := &Chunk{RGBA: whiteImage(tt.width, tt.height)}
+			c := &Chunk{Image: whiteImage(tt.width, tt.height)}
 			got := c.MaxPayloadSize()
 			assert.Equal(t, want, got, "MaxPayloadSize() = %v, want %v", got, want)
 		})
@@ -77,7 +79,7 @@ func TestChunk_MaxPayloadSize1(t *testing.T) {
 
 func TestChunk_WriteEmptyInput(t *testing.T) {
 
-	chunk := Chunk{RGBA: blackImage(2, 2)}
+	chunk := Chunk{Image: blackImage(2, 2)}
 
 	n, err := chunk.Write([]byte{})
 	require.NoError(t, err)
@@ -86,23 +88,23 @@ func TestChunk_WriteEmptyInput(t *testing.T) {
 	assert.Equal(t, 0, chunk.wOff)
 
 	// Test expected bit representation
-	for _, p := range chunk.Pix {
+	for _, p := range chunk.pix() {
 		assert.EqualValues(t, 0, p)
 	}
 }
 
 func TestChunk_WriteSetAllBitsToOne(t *testing.T) {
 
-	chunk := Chunk{RGBA: blackImage(2, 2)}
+	chunk := Chunk{Image: blackImage(2, 2)}
 
 	n, err := chunk.Write([]byte{ones})
 	require.NoError(t, err)
 
 	assert.Equal(t, 1, n)
-	assert.Equal(t, 1, chunk.wOff)
+	assert.Equal(t, 1*BitsPerByte, chunk.wOff)
 
 	// Test expected bit representation
-	for i, p := range chunk.Pix {
+	for i, p := range chunk.pix() {
 		if i >= 8 {
 			break
 		}
@@ -116,13 +118,13 @@ func TestChunk_WriteSetAllBitsToOne(t *testing.T) {
 
 func TestChunk_WriteSetMixedBits(t *testing.T) {
 
-	chunk := Chunk{RGBA: blackImage(3, 2)}
+	chunk := Chunk{Image: blackImage(3, 2)}
 
 	n, err := chunk.Write([]byte{0b11110000, 0b00001111})
 	require.NoError(t, err)
 
 	assert.Equal(t, 2, n)
-	assert.Equal(t, 2, chunk.wOff)
+	assert.Equal(t, 2*BitsPerByte, chunk.wOff)
 
 	// Test expected bit representation
 	expects := []PixExpect{
@@ -145,27 +147,27 @@ func TestChunk_WriteSetMixedBits(t *testing.T) {
 
 func TestChunk_WriteMoreThanPossible(t *testing.T) {
 
-	chunk := Chunk{RGBA: blackImage(3, 2)}
+	chunk := Chunk{Image: blackImage(3, 2)}
 
 	n, err := chunk.Write([]byte{ones, ones, ones})
 	assert.EqualError(t, err, io.EOF.Error())
 
 	assert.Equal(t, 2, n)
-	assert.Equal(t, 2, chunk.wOff)
+	assert.Equal(t, 2*BitsPerByte, chunk.wOff)
 
 	// Test expected bit representation
-	assert.EqualValues(t, 1, chunk.Pix[20])
+	assert.EqualValues(t, 1, chunk.pix()[20])
 }
 
 func TestChunk_WritePartialByteWritten(t *testing.T) {
 
-	chunk := Chunk{RGBA: blackImage(1, 3)} // 12 bytes
+	chunk := Chunk{Image: blackImage(1, 3)} // 12 bytes
 
 	n, err := chunk.Write([]byte{ones, ones})
 	assert.EqualError(t, err, io.EOF.Error())
 
 	assert.Equal(t, 1, n)
-	assert.Equal(t, 1, chunk.wOff)
+	assert.Equal(t, 1*BitsPerByte, chunk.wOff)
 
 	// Test expected bit representation
 	expects := []PixExpect{
@@ -186,14 +188,14 @@ func TestChunk_WritePartialByteWritten(t *testing.T) {
 }
 
 func TestRead_MatchingLength(t *testing.T) {
-	chunk := Chunk{RGBA: whiteImage(4, 6)} // 24 pixel -> 24*3=72 available LSBs -> 72/8 = 9 bytes
+	chunk := Chunk{Image: whiteImage(4, 6)} // 24 pixel -> 24*3=72 available LSBs -> 72/8 = 9 bytes
 
 	buffer := make([]byte, 9)
 	n, err := chunk.Read(buffer)
 	require.NoError(t, err)
 
 	assert.Equal(t, 9, n)
-	assert.Equal(t, 9, chunk.rOff)
+	assert.Equal(t, 9*BitsPerByte, chunk.rOff)
 
 	for _, b := range buffer {
 		assert.EqualValues(t, ones, b)
@@ -201,14 +203,14 @@ func TestRead_MatchingLength(t *testing.T) {
 }
 
 func TestRead_SmallerReadBuffer(t *testing.T) {
-	chunk := Chunk{RGBA: whiteImage(2, 3)} // 6 Pixel -> 6*3=18 available LSBs -> 18/8 = 2.25 bytes
+	chunk := Chunk{Image: whiteImage(2, 3)} // 6 Pixel -> 6*3=18 available LSBs -> 18/8 = 2.25 bytes
 
 	buffer := make([]byte, 1)
 	n, err := chunk.Read(buffer)
 	require.NoError(t, err)
 
 	assert.Equal(t, 1, n)
-	assert.Equal(t, 1, chunk.rOff)
+	assert.Equal(t, 1*BitsPerByte, chunk.rOff)
 
 	for _, b := range buffer {
 		assert.EqualValues(t, ones, b)
@@ -216,28 +218,28 @@ func TestRead_SmallerReadBuffer(t *testing.T) {
 }
 
 func TestRead_LargerReadBuffer(t *testing.T) {
-	chunk := Chunk{RGBA: whiteImage(2, 3)} // 6 Pixel -> 6*3=18 available LSBs -> 18/8 = 2.25 bytes
+	chunk := Chunk{Image: whiteImage(2, 3)} // 6 Pixel -> 6*3=18 available LSBs -> 18/8 = 2.25 bytes
 
 	buffer := make([]byte, 3)
 	n, err := chunk.Read(buffer)
 	require.EqualError(t, err, io.EOF.Error())
 
 	assert.Equal(t, 2, n)
-	assert.Equal(t, 2, chunk.rOff)
+	assert.Equal(t, 2*BitsPerByte, chunk.rOff)
 
 	assert.EqualValues(t, ones, buffer[0])
 	assert.EqualValues(t, ones, buffer[0])
 }
 
 func TestRead_PartialReadBuffer(t *testing.T) {
-	chunk := Chunk{RGBA: whiteImage(1, 3)} // 3 Pixel -> 3*3=9 available LSBs -> 9/8 = 1 byte
+	chunk := Chunk{Image: whiteImage(1, 3)} // 3 Pixel -> 3*3=9 available LSBs -> 9/8 = 1 byte
 
 	buffer := make([]byte, 2)
 	n, err := chunk.Read(buffer)
 	require.EqualError(t, err, io.EOF.Error())
 
 	assert.Equal(t, 1, n)
-	assert.Equal(t, 1, chunk.rOff)
+	assert.Equal(t, 1*BitsPerByte, chunk.rOff)
 
 	assert.EqualValues(t, ones, buffer[0])
 	assert.EqualValues(t, zeroes, buffer[1])
@@ -245,19 +247,19 @@ func TestRead_PartialReadBuffer(t *testing.T) {
 
 func TestReadWrite(t *testing.T) {
 	payload := []byte{42, 24}
-	chunk := Chunk{RGBA: whiteImage(2, 3)} // 6 Pixel -> 6*3=18 available LSBs -> 18/8 = 2.25 byte
+	chunk := Chunk{Image: whiteImage(2, 3)} // 6 Pixel -> 6*3=18 available LSBs -> 18/8 = 2.25 byte
 
 	n, err := chunk.Write(payload)
 	require.NoError(t, err)
 	assert.Equal(t, 2, n)
-	assert.Equal(t, 2, chunk.wOff)
+	assert.Equal(t, 2*BitsPerByte, chunk.wOff)
 
 	parsed := make([]byte, 2)
 	n, err = chunk.Read(parsed)
 	require.NoError(t, err)
 
 	assert.Equal(t, 2, n)
-	assert.Equal(t, 2, chunk.rOff)
+	assert.Equal(t, 2*BitsPerByte, chunk.rOff)
 
 	assert.EqualValues(t, 42, parsed[0])
 	assert.EqualValues(t, 24, parsed[1])
@@ -267,35 +269,355 @@ func TestReadWriteSeparate(t *testing.T) {
 	hash := sha256.New()
 	payload := hash.Sum([]byte{})
 
-	chunk := Chunk{RGBA: whiteImage(100, 100)}
+	chunk := Chunk{Image: whiteImage(100, 100)}
 
 	n, err := chunk.Write(payload[0:20])
 	require.NoError(t, err)
 	assert.Equal(t, 20, n)
-	assert.Equal(t, 20, chunk.wOff)
+	assert.Equal(t, 20*BitsPerByte, chunk.wOff)
 
 	n, err = chunk.Write(payload[20:])
 	require.NoError(t, err)
 	assert.Equal(t, 12, n)
-	assert.Equal(t, 32, chunk.wOff)
+	assert.Equal(t, 32*BitsPerByte, chunk.wOff)
 
 	parsed1 := make([]byte, 20)
 	n, err = chunk.Read(parsed1)
 	require.NoError(t, err)
 
 	assert.Equal(t, 20, n)
-	assert.Equal(t, 20, chunk.rOff)
+	assert.Equal(t, 20*BitsPerByte, chunk.rOff)
 
 	parsed2 := make([]byte, 12)
 	n, err = chunk.Read(parsed2)
 	require.NoError(t, err)
 
 	assert.Equal(t, 12, n)
-	assert.Equal(t, 32, chunk.rOff)
+	assert.Equal(t, 32*BitsPerByte, chunk.rOff)
 
 	assert.True(t, bytes.Equal(payload, append(parsed1, parsed2...)))
 }
 
+func TestChunk_WriteBitsReadBits(t *testing.T) {
+	chunk := Chunk{Image: blackImage(2, 2)} // 4 Pixel -> 4*3=12 available LSBs
+
+	require.NoError(t, chunk.WriteBool(true))
+	require.NoError(t, chunk.WriteBits(0b101, 3))
+	require.NoError(t, chunk.WriteBits(0b01101011, 8))
+	assert.Equal(t, 12, chunk.wOff)
+
+	assert.EqualError(t, chunk.WriteBool(true), io.EOF.Error())
+	assert.Equal(t, 12, chunk.wOff)
+
+	expects := []PixExpect{
+		{0, 1},
+		{1, 1},
+		{2, 0},
+		{3, 0},
+		{4, 1},
+		{5, 0},
+		{6, 1},
+	}
+	assertPixExpect(t, chunk, expects)
+
+	b, err := chunk.ReadBool()
+	require.NoError(t, err)
+	assert.True(t, b)
+
+	v, err := chunk.ReadBits(3)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0b101, v)
+
+	v, err = chunk.ReadBits(8)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0b01101011, v)
+	assert.Equal(t, 12, chunk.rOff)
+
+	_, err = chunk.ReadBits(1)
+	assert.EqualError(t, err, io.EOF.Error())
+}
+
+func TestChunk_WriteBitsNotEnoughSpace(t *testing.T) {
+	chunk := Chunk{Image: blackImage(1, 2)} // 2 Pixel -> 2*3=6 available LSBs
+
+	require.NoError(t, chunk.WriteBits(0b11, 2))
+
+	err := chunk.WriteBits(0b11111, 5)
+	assert.EqualError(t, err, io.EOF.Error())
+	assert.Equal(t, 2, chunk.wOff)
+
+	// Nothing of the second write should have been written
+	for i := 2; i < len(chunk.pix()); i++ {
+		assert.EqualValues(t, 0, chunk.pix()[i])
+	}
+}
+
+func TestPathCountBitLength(t *testing.T) {
+	tests := []struct {
+		chunkCount int
+		want       int
+	}{
+		{2, 1},
+		{4, 2},
+		{8, 2},
+		{16, 3},
+		{414, 4},
+		{1 << 16, 5},
+	}
+	for _, tt := range tests {
+		name := fmt.Sprintf("%d chunks need %d bits for the path count", tt.chunkCount, tt.want)
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tt.want, PathCountBitLength(tt.chunkCount))
+		})
+	}
+}
+
+func TestChunk_WriteSelectedChannels(t *testing.T) {
+	chunk := Chunk{Image: blackImage(2, 2), Channels: ChannelB | ChannelA}
+	assert.Equal(t, 8, chunk.LSBCount())
+
+	n, err := chunk.Write([]byte{0b10110011})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// Only the B and A values carry payload bits
+	expects := []PixExpect{
+		{0, 0}, {1, 0}, {2, 1}, {3, 0},
+		{4, 0}, {5, 0}, {6, 1}, {7, 1},
+		{8, 0}, {9, 0}, {10, 0}, {11, 0},
+		{12, 0}, {13, 0}, {14, 1}, {15, 1},
+	}
+	assertPixExpect(t, chunk, expects)
+
+	parsed := make([]byte, 1)
+	_, err = chunk.Read(parsed)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0b10110011, parsed[0])
+}
+
+func TestChunk_WriteDepth(t *testing.T) {
+	chunk := Chunk{Image: blackImage(1, 1), Depth: 2}
+	assert.Equal(t, 6, chunk.LSBCount())
+
+	require.NoError(t, chunk.WriteBits(0b100111, 6))
+
+	assert.EqualValues(t, 0b10, chunk.pix()[0])
+	assert.EqualValues(t, 0b01, chunk.pix()[1])
+	assert.EqualValues(t, 0b11, chunk.pix()[2])
+	assert.EqualValues(t, 0b00, chunk.pix()[3])
+
+	v, err := chunk.ReadBits(6)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0b100111, v)
+}
+
+func TestChunk_WritePlane(t *testing.T) {
+	chunk := Chunk{Image: blackImage(1, 1), Depth: 1, Planes: 2, Plane: 1}
+	assert.Equal(t, 3, chunk.LSBCount())
+
+	require.NoError(t, chunk.WriteBits(0b101, 3))
+	assert.EqualValues(t, 0b10, chunk.pix()[0])
+	assert.EqualValues(t, 0b00, chunk.pix()[1])
+	assert.EqualValues(t, 0b10, chunk.pix()[2])
+
+	// The bits of both planes are excluded from the hash
+	before, err := chunk.CalculateHash()
+	require.NoError(t, err)
+	chunk.pix()[1] = 0b11
+	after, err := chunk.CalculateHash()
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}
+
+func TestChunk_CalculateHashIgnoresDepthBits(t *testing.T) {
+	chunk := Chunk{Image: blackImage(2, 2), Depth: 3}
+	before, err := chunk.CalculateHash()
+	require.NoError(t, err)
+
+	chunk.pix()[0] = 0b00000111
+	after, err := chunk.CalculateHash()
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	chunk.pix()[0] = 0b00001000
+	after, err = chunk.CalculateHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, before, after)
+}
+
+func TestChunk_CalculateHashIgnoresPayloadChannelsOnly(t *testing.T) {
+	chunk := Chunk{Image: blackImage(2, 2), Channels: ChannelB}
+	before, err := chunk.CalculateHash()
+	require.NoError(t, err)
+
+	// Changing the LSB of a payload channel doesn't change the hash
+	chunk.pix()[2] = 1
+	after, err := chunk.CalculateHash()
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	// Changing the LSB of a channel that doesn't carry payload does
+	chunk.pix()[0] = 1
+	after, err = chunk.CalculateHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, before, after)
+}
+
+func TestChunk_CalculateHashCoversAlpha(t *testing.T) {
+	chunk := Chunk{Image: whiteImage(2, 2)}
+	before, err := chunk.CalculateHash()
+	require.NoError(t, err)
+
+	// Making a pixel semi-transparent changes the hash although the color values stay the same
+	chunk.pix()[3] = 128
+	after, err := chunk.CalculateHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, before, after)
+}
+
+func TestChunk_SubImage(t *testing.T) {
+	img := blackImage(4, 4)
+	chunk := Chunk{Image: img.SubImage(image.Rect(2, 2, 4, 4)).(*image.NRGBA)}
+	assert.Equal(t, 4, chunk.PixelCount())
+
+	require.NoError(t, chunk.WriteBits(0b111, 3))
+
+	// The first payload bits are written to the top left pixel of the sub image
+	i := img.PixOffset(2, 2)
+	assert.EqualValues(t, []byte{1, 1, 1, 0}, img.Pix[i:i+4])
+	assert.EqualValues(t, 0, img.Pix[img.PixOffset(1, 2)])
+}
+
+func TestChunk_16Bit(t *testing.T) {
+	img := image.NewNRGBA64(image.Rect(0, 0, 2, 1))
+	chunk := Chunk{Image: img}
+	assert.Equal(t, 6, chunk.LSBCount())
+
+	before, err := chunk.CalculateHash()
+	require.NoError(t, err)
+
+	require.NoError(t, chunk.WriteBits(0b101101, 6))
+
+	// Only the least significant bit of the lower byte of each value carries payload
+	assert.EqualValues(t, []byte{0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0, 0}, img.Pix)
+
+	v, err := chunk.ReadBits(6)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0b101101, v)
+
+	// The upper 15 bits are hashed
+	after, err := chunk.CalculateHash()
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	img.Pix[1] = 0b10
+	after, err = chunk.CalculateHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, before, after)
+}
+
+func TestChunk_Gray(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	chunk := Chunk{Image: img, Channels: ChannelB}
+	assert.Equal(t, 6, chunk.LSBCount())
+
+	require.NoError(t, chunk.WriteBits(0b110100, 6))
+	assert.EqualValues(t, []byte{1, 1, 0, 1, 0, 0}, img.Pix)
+
+	v, err := chunk.ReadBits(6)
+	require.NoError(t, err)
+	assert.EqualValues(t, 0b110100, v)
+}
+
+func TestChunk_Gray16(t *testing.T) {
+	img := image.NewGray16(image.Rect(0, 0, 2, 1))
+	chunk := Chunk{Image: img, Depth: 2}
+	assert.Equal(t, 4, chunk.LSBCount())
+
+	require.NoError(t, chunk.WriteBits(0b1001, 4))
+	assert.EqualValues(t, []byte{0, 0b10, 0, 0b01}, img.Pix)
+}
+
+func TestChunk_PalettedHashCoversPalette(t *testing.T) {
+	img := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.Black, color.White, color.White})
+	chunk := Chunk{Image: img}
+
+	before, err := chunk.CalculateHash()
+	require.NoError(t, err)
+
+	// The parity of the index carries payload
+	img.Pix[0] = 1
+	after, err := chunk.CalculateHash()
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	// Selecting another pair of colors changes the content
+	img.Pix[0] = 2
+	after, err = chunk.CalculateHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, before, after)
+
+	// So does changing the palette
+	img.Pix[0] = 0
+	img.Palette[0] = color.Gray{Y: 1}
+	after, err = chunk.CalculateHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, before, after)
+}
+
+func TestChunk_DCT(t *testing.T) {
+	img := jpegdct.FromImage(whiteImage(16, 8), 90)
+	chunk := Chunk{Image: img, Channels: ChannelB}
+	assert.Equal(t, 2, chunk.PixelCount())
+	assert.Equal(t, 2*22, chunk.LSBCount())
+
+	before, err := chunk.CalculateHash()
+	require.NoError(t, err)
+
+	// The payload is written to the mid-frequency luminance coefficients regardless of the channels
+	require.NoError(t, chunk.WriteBits(0b101, 3))
+	assert.EqualValues(t, 1, img.Coefficient(0, 0, 0, jpegdct.Zigzag[6]))
+	assert.EqualValues(t, 0, img.Coefficient(0, 0, 0, jpegdct.Zigzag[7]))
+	assert.EqualValues(t, 1, img.Coefficient(0, 0, 0, jpegdct.Zigzag[8]))
+
+	after, err := chunk.CalculateHash()
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	// Changing any other coefficient changes the content
+	img.SetCoefficient(1, 0, 2, 0, 1)
+	after, err = chunk.CalculateHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, before, after)
+}
+
+func TestChunk_KeyedReadWrite(t *testing.T) {
+	payload := []byte{42, 24, 0xff, 0}
+	chunk := Chunk{Image: blackImage(10, 10), Key: []byte("secret"), Index: 7}
+
+	n, err := chunk.Write(payload)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	// The payload is not placed sequentially
+	sequential := Chunk{Image: chunk.Image}
+	parsed := make([]byte, 4)
+	_, err = sequential.Read(parsed)
+	require.NoError(t, err)
+	assert.NotEqual(t, payload, parsed)
+
+	// Reading with another key or chunk index doesn't reveal the payload
+	other := Chunk{Image: chunk.Image, Key: []byte("secret"), Index: 8}
+	_, err = other.Read(parsed)
+	require.NoError(t, err)
+	assert.NotEqual(t, payload, parsed)
+
+	reader := Chunk{Image: chunk.Image, Key: []byte("secret"), Index: 7}
+	_, err = reader.Read(parsed)
+	require.NoError(t, err)
+	assert.Equal(t, payload, parsed)
+}
+
 // PixExpect holds an index and expected bit value.
 type PixExpect struct {
 	idx int
@@ -305,9 +627,36 @@ type PixExpect struct {
 func assertPixExpect(t *testing.T, chunk Chunk, expects []PixExpect) {
 	for _, e := range expects {
 		got := 0
-		if bit.GetLSB(chunk.Pix[e.idx]) {
+		if bit.GetLSB(chunk.pix()[e.idx]) {
 			got = 1
 		}
-		assert.EqualValues(t, e.bit, got, "Pixel at idx %d has val %d, want: %d", e.idx, chunk.Pix[e.idx], e.bit)
+		assert.EqualValues(t, e.bit, got, "Pixel at idx %d has val %d, want: %d", e.idx, chunk.pix()[e.idx], e.bit)
 	}
 }
+
+func TestChunk_CalculateHashBindsPosition(t *testing.T) {
+	img := blackImage(4, 2)
+	left := &Chunk{Image: img.SubImage(image.Rect(0, 0, 2, 2)).(*image.NRGBA), Index: 0, ImageSize: image.Pt(4, 2)}
+	right := &Chunk{Image: img.SubImage(image.Rect(2, 0, 4, 2)).(*image.NRGBA), Index: 1, ImageSize: image.Pt(4, 2)}
+
+	// Without a position chunks with the same content are interchangeable
+	unbound := &Chunk{Image: left.Image}
+	unboundRight := &Chunk{Image: right.Image, Index: 1}
+	unboundHash, err := unbound.CalculateHash()
+	require.NoError(t, err)
+	unboundRightHash, err := unboundRight.CalculateHash()
+	require.NoError(t, err)
+	assert.Equal(t, unboundHash, unboundRightHash)
+
+	leftHash, err := left.CalculateHash()
+	require.NoError(t, err)
+	rightHash, err := right.CalculateHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, leftHash, rightHash)
+
+	// The image size is bound as well
+	left.ImageSize = image.Pt(4, 3)
+	otherSize, err := left.CalculateHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, leftHash, otherSize)
+}
@@ -1,147 +1,399 @@
 package chunk
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"bytes"
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
-	"log"
-	"path"
+
+	"dennis-tra/image-stego/pkg/merkle"
 )
 
-func Decode(filepath string) error {
+// The errors that tell why the Merkle proof embedded in a chunk can't be followed. They indicate that the
+// payload in the LSBs (or DCT coefficients) has been damaged rather than the content of the chunk.
+var (
+	// errCorruptHeader is returned if the number of proof hashes doesn't match the position of the chunk or
+	// the root stored by a single chunk doesn't match its check value.
+	errCorruptHeader = errors.New("corrupt proof header")
+
+	// errInvalidSide is returned if the side flag of a proof hash doesn't match the position of the chunk.
+	errInvalidSide = errors.New("invalid side flag")
 
-	log.Println("Opening image:", filepath)
-	probeImg, err := OpenImageFile(filepath)
+	// errTruncatedProof is returned if the chunk ends before the proof does.
+	errTruncatedProof = errors.New("truncated proof")
+)
+
+// proofErrorStatus returns the chunk status for an error of embeddedProof.root or v0ChunkRoot. It returns false
+// if the error doesn't concern the embedded proof.
+func proofErrorStatus(err error) (ChunkStatus, bool) {
+	switch {
+	case errors.Is(err, errCorruptHeader):
+		return ChunkCorruptHeader, true
+	case errors.Is(err, errInvalidSide):
+		return ChunkInvalidSide, true
+	case errors.Is(err, errTruncatedProof):
+		return ChunkTruncatedProof, true
+	default:
+		return ChunkRootMismatch, false
+	}
+}
+
+// Decode verifies the encoded image at the given path and returns the report of the verification. An
+// error is only returned if the image can't be verified at all, a tampered image is reported as such. The
+// progress is logged to the logger of the options if there is one.
+func Decode(filepath string, dopts DecodeOptions) (*Report, error) {
+	logger := dopts.logger()
+
+	logger.Println("Opening image:", filepath)
+	probeImg, opts, recorded, err := openEncodedImageFile(filepath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	log.Println("Calculating bounds...")
-	bounds := CalculateChunkBounds(probeImg)
+	if opts.Keyed() && len(dopts.Key) == 0 {
+		return nil, errors.New("the image was encoded with a secret key, please provide it")
+	}
 
-	log.Println("Calculating Merkle tree roots for every chunk...")
+	if opts.Authenticated() && len(dopts.MACKey) == 0 {
+		return nil, errors.New("the image was encoded with an HMAC key, please provide it")
+	}
 
-	// rootHashes is a map from the root hash of a chunk to a list of indices where this root hash can be found
-	rootHashes := map[string][]ChunkIndex{}
-	for x, boundRow := range bounds {
-		for y, bound := range boundRow {
+	if len(dopts.Root) > 0 && len(dopts.Root) != opts.Hash.Size() {
+		return nil, fmt.Errorf("the trusted root has %d bytes but the image was encoded with %d byte %s hashes", len(dopts.Root), opts.Hash.Size(), opts.Hash)
+	}
 
-			chunk := &Chunk{
-				RGBA: ImageToRGBA(probeImg.SubImage(bound)),
-			}
+	// The report carries the options without the keys
+	report := &Report{Options: opts, Trusted: len(dopts.Root) > 0}
+	opts.Key = dopts.Key
+	opts.MACKey = dopts.MACKey
 
-			// First byte contains the number of hashes in this chunk (called paths in the merkletree package)
-			pathCount := make([]byte, 1)
-			_, err := chunk.Read(pathCount)
-			if err != nil {
-				return err
-			}
+	logger.Println("Options:", opts)
+	logger.Println("Calculating bounds...")
+	var levels [][][]image.Rectangle
+	if recorded {
+		if levels, err = CalculateLevelBounds(probeImg, opts); err != nil {
+			return nil, err
+		}
+	} else {
+		// Images without options were encoded by the first release (see v0.go), which had no keys
+		logger.Println("The image carries no options, verifying it in the format of the first release")
+		probeImg = v0Image(probeImg)
+		opts.Key, opts.MACKey = nil, nil
+		levels = [][][]image.Rectangle{v0Bounds(probeImg)}
+	}
+	chunkCount := 0
+	for _, bounds := range levels {
+		chunkCount += len(bounds) * len(bounds[0])
+	}
 
-			chunkHash, _ := chunk.CalculateHash()
-			prevHash := chunkHash
-			for i := 0; i < int(pathCount[0]); i++ {
-				// The order in which the hashes should be concatenated to calculate the composite hash
-				side := make([]byte, 1)
-
-				// The hash data for the new composite hash
-				data := make([]byte, 32)
-
-				// EOFs can happen if pathCount is wrong due to image manipulation
-				// of that specific chunk. pathCount could be way larger than
-				// the maximum chunk payload, therefore an EOF can happen.
-				_, err := chunk.Read(side)
-				if err != nil {
-					break
-				}
+	// The position of each chunk is bound into its hash
+	imageSize := pixelRect(probeImg, probeImg.Bounds()).Size()
 
-				_, err = chunk.Read(data)
-				if err != nil {
-					break
-				}
+	logger.Println("Calculating Merkle tree roots for every chunk...")
 
-				hsh := sha256.New()
+	// chunks holds all chunks of all levels in the order of their indices, leaves their hashes and proofs their
+	// embedded Merkle proofs, which are nil for images of the first release and if the proof can't be read at
+	// all. subBlocks holds the embedded sub-block hashes of the chunks whose proofs belong to their positions.
+	chunks := make([]*Chunk, 0, chunkCount)
+	leaves := make([][]byte, chunkCount)
+	proofs := make([]*embeddedProof, chunkCount)
+	subBlocks := make([][][]byte, chunkCount)
+	for level, bounds := range levels {
+		for x, boundRow := range bounds {
+			for y, bound := range boundRow {
 
-				if side[0] == 0 {
-					prevHash = append(data, prevHash...)
-				} else if side[0] == 1 {
-					prevHash = append(prevHash, data...)
+				chunk := &Chunk{
+					Image:     probeImg.SubImage(bound).(Image),
+					Channels:  opts.Channels,
+					Depth:     opts.Depth,
+					Planes:    len(levels),
+					Plane:     len(levels) - 1 - level,
+					Hash:      opts.Hash,
+					MACKey:    opts.MACKey,
+					Index:     len(chunks),
+					ImageSize: imageSize,
+					Key:       opts.Key,
+				}
+				chunks = append(chunks, chunk)
+
+				var root []byte
+				if !recorded {
+					root, err = v0ChunkRoot(chunk)
 				} else {
-					break
+					if leaves[chunk.Index], err = chunk.CalculateHash(); err != nil {
+						return nil, err
+					}
+					proofs[chunk.Index], err = readEmbeddedProof(chunk, opts, chunkCount)
+					if err == nil {
+						root, err = proofs[chunk.Index].root(opts.merkleHasher(), chunk.Index, chunkCount, leaves[chunk.Index])
+					}
+					if err == nil {
+						// The sub-block hashes follow the proof, the signature follows them
+						if subBlocks[chunk.Index], err = readSubBlockHashes(chunk, chunkCount, opts); err != nil {
+							return nil, err
+						}
+					}
 				}
 
-				hsh.Write(prevHash)
-				prevHash = hsh.Sum(nil)
+				status, isProofErr := proofErrorStatus(err)
+				if err != nil && !isProofErr {
+					return nil, err
+				}
+
+				report.Chunks = append(report.Chunks, ChunkReport{
+					Index:  chunk.Index,
+					Level:  level,
+					Column: x,
+					Row:    y,
+					Bounds: pixelRect(probeImg, bound),
+					Root:   root,
+					Status: status,
+					Tamper: TamperUnknown,
+				})
 			}
+		}
+	}
+
+	// The root that most chunks lead to is assumed to be the original one unless a trusted root is given,
+	// which every chunk is judged against instead. A root that no two chunks agree on is not chosen, as any
+	// chunk could be the one that leads to it.
+	report.Roots = rootHistogram(report.Chunks)
+	agreeing := 0
+	if len(report.Roots) > 0 {
+		agreeing = report.Roots[0].Count
+	}
+
+	// storedRoot is set if a single chunk is judged against the root it stores
+	storedRoot := false
+	if report.Trusted {
+		report.Root = dopts.Root
+	} else if chunkCount == 1 && proofs[0] != nil && proofs[0].storedRoot != nil {
+		// A single chunk can't be outvoted, its content is judged against the root it stores instead
+		report.Root = proofs[0].storedRoot
+		storedRoot = true
+	} else if agreeing > 1 {
+		report.Root = report.Roots[0].Root
+	}
 
-			// This is the root hash for the chunk at hand
-			rootHash := hex.EncodeToString(prevHash)
+	// trusted marks the chunks that lead to the root
+	trusted := make([]bool, chunkCount)
+	matches := 0
+	for i, c := range report.Chunks {
+		if c.Root != nil && bytes.Equal(c.Root, report.Root) {
+			report.Chunks[i].Status = ChunkValid
+			report.Chunks[i].Tamper = TamperNone
+			trusted[i] = true
+			matches++
+		}
+	}
 
-			// persist chunk root hash index to color it in later on if there is only one with this hash.
-			if _, exists := rootHashes[rootHash]; !exists {
-				rootHashes[rootHash] = []ChunkIndex{}
+	// Images without options that were encoded before options were recorded still have chunks that agree.
+	// Images with options whose chunks don't agree at all were most likely decoded with the wrong keys.
+	switch {
+	case !recorded && agreeing < 2:
+		report.Status = StatusNotEncoded
+		if !report.Trusted {
+			report.Root = nil
+		}
+		return report, nil
+	case chunkCount > 1 && agreeing < 2 && matches == 0:
+		report.Status = StatusNoCommonRoot
+		return report, nil
+	case report.Trusted && matches == 0:
+		report.Status = StatusNoTrustedMatch
+	case matches == chunkCount:
+		report.Status = StatusClean
+	default:
+		report.Status = StatusTampered
+	}
+
+	// The manipulations can only be told apart if the known nodes of the tree of the root are trustworthy,
+	// which they aren't in the tree of the first release, and if any are known at all. Otherwise the chunks
+	// keep the unknown manipulation.
+	if report.Status == StatusTampered && recorded && (matches > 0 || storedRoot) {
+		logger.Println("Classifying manipulated chunks...")
+		if err = classifyTampering(report, chunks, leaves, proofs, trusted, opts); err != nil {
+			return nil, err
+		}
+
+		// The sub-block hashes of chunks that carry their original proof localise the modified content
+		for i, c := range report.Chunks {
+			if c.Tamper == TamperContent && len(subBlocks[i]) > 0 {
+				report.Chunks[i].Changed = changedSubBlocks(chunks[i], subBlocks[i])
 			}
-			rootHashes[rootHash] = append(rootHashes[rootHash], ChunkIndex{x, y})
 		}
 	}
 
-	// Find the root hash that appeared multiple times
-	rootCount := 0
-	merkleRoot := ""
-	for chunkRoot, indices := range rootHashes {
-		if len(indices) > rootCount {
-			rootCount = len(indices)
-			merkleRoot = chunkRoot
+	if opts.Signed() && matches > 0 {
+		report.Signature = verifySignature(chunks, trusted, report.Root, opts, dopts)
+	} else if len(dopts.TrustedKeys) > 0 {
+		// An unsigned root isn't vouched for by any of the trusted keys
+		report.Signature = &SignatureReport{}
+	}
+
+	// With trusted keys an intact image is only accepted if one of them signed its root
+	if report.Signature != nil {
+		report.Signature.Required = len(dopts.TrustedKeys) > 0
+		if report.Status == StatusClean && report.Signature.Required && !report.Signature.Verified {
+			report.Status = StatusUntrustedSignature
 		}
 	}
 
-	if len(rootHashes) == 1 {
-		log.Println("This image has not been tampered with. All chunks have the same Merkle Root:", merkleRoot)
-		return nil
+	if report.Status != StatusTampered {
+		return report, nil
 	}
 
-	log.Println("Found multiple Merkle Roots. This image has been tampered with! RootHashes:")
+	logger.Println("Drawing overlay image of altered regions...")
+
+	// Chunks whose changes are localised are tinted lightly and only their changed sub-blocks are marked.
+	// The chunks of coarser levels are tinted lightly as well, so the finer ones stand out within them.
+	report.Overlay = ImageToRGBA(pixelImage(probeImg))
+	for _, c := range report.Tampered() {
+		var alpha uint8 = 80
+		if c.Level < len(levels)-1 {
+			alpha = 32
+		}
 
-	log.Println("Count\tRoot")
-	for root, indexes := range rootHashes {
-		log.Printf("%5d\t%s\n", len(indexes), root)
+		marked := []image.Rectangle{c.Bounds}
+		if len(c.Changed) > 0 {
+			drawOverlay(report.Overlay, c.Bounds, c.Tamper.Color(), 24)
+			marked = c.Changed
+		}
+		for _, r := range marked {
+			drawOverlay(report.Overlay, r, c.Tamper.Color(), alpha)
+		}
 	}
 
-	log.Println("Drawing overlay image of altered regions...")
+	return report, nil
+}
 
-	overlayImg := ImageToRGBA(probeImg.SubImage(probeImg.Bounds()))
-	for root, indices := range rootHashes {
-		for _, idx := range indices {
+// drawOverlay draws the given color with the given opacity over the given rectangle of the overlay image.
+func drawOverlay(overlay *image.RGBA, r image.Rectangle, clr color.RGBA, alpha uint8) {
+	draw.DrawMask(
+		overlay,
+		r,
+		&image.Uniform{C: clr},
+		image.Point{},
+		&image.Uniform{C: color.RGBA{R: 255, G: 255, B: 255, A: alpha}},
+		image.Point{},
+		draw.Over,
+	)
+}
 
-			if root == merkleRoot {
-				continue
-			}
+// verifySignature reads the signature of the given Merkle root from the spare capacity of the trusted
+// chunks, which lead to that root, and verifies it with the trusted keys.
+func verifySignature(chunks []*Chunk, trusted []bool, root []byte, opts Options, dopts DecodeOptions) *SignatureReport {
+	block, found := readSignatureBlock(chunks, trusted, opts)
+	if !found {
+		return &SignatureReport{}
+	}
+
+	report := &SignatureReport{Recovered: true, KeyID: block[:KeyIDSize]}
+	key, err := verifyRootSignature(block, root, dopts.TrustedKeys)
+	if !errors.Is(err, errUnknownSigner) {
+		report.Signer = key.Name
+	}
+	report.Verified = err == nil
+
+	return report
+}
+
+// embeddedProof is the Merkle proof as it is embedded in a chunk, independent of the position of the chunk.
+// It holds the sides and hashes that could be read before the chunk ended. The root is only stored if the
+// image is a single chunk, which has no proof. It is nil if it couldn't be read or doesn't match its check
+// value (damagedRoot).
+type embeddedProof struct {
+	pathCount   int
+	sides       []bool
+	hashes      [][]byte
+	storedRoot  []byte
+	damagedRoot bool
+}
+
+// readEmbeddedProof reads the number of proof hashes and as many sides and hashes from the given chunk as
+// it holds. An error wrapping errCorruptHeader is returned if not even the number can be read.
+func readEmbeddedProof(chunk *Chunk, opts Options, chunkCount int) (*embeddedProof, error) {
+	// The first bits contain the number of hashes in this chunk
+	pathCount, err := chunk.ReadBits(uint8(PathCountBitLength(chunkCount)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errCorruptHeader, err)
+	}
 
-			draw.DrawMask(
-				overlayImg,
-				bounds[idx.x][idx.y],
-				&image.Uniform{C: color.RGBA{R: 255, A: 255}},
-				image.Point{},
-				&image.Uniform{C: color.RGBA{R: 255, G: 255, B: 255, A: 80}},
-				image.Point{},
-				draw.Over,
-			)
+	p := &embeddedProof{pathCount: int(pathCount)}
+	for i := 0; i < p.pathCount; i++ {
+		side, err := chunk.ReadBool()
+		if err != nil {
+			break
 		}
+		p.sides = append(p.sides, side)
+
+		hash := make([]byte, opts.ProofHashBitLength()/BitsPerByte)
+		if _, err = chunk.Read(hash); err != nil {
+			break
+		}
+		p.hashes = append(p.hashes, hash)
 	}
 
-	overlayFilepath := path.Join(path.Dir(filepath), SetExtension(path.Base(filepath), ".overlay.png"))
-	log.Println("Saving overlay image:", overlayFilepath)
-	err = SaveImageFile(overlayFilepath, overlayImg)
+	if chunkCount == 1 {
+		root := make([]byte, opts.Hash.Size())
+		check := make([]byte, RootCheckBitLength/BitsPerByte)
+		if _, err = chunk.Read(root); err != nil {
+			return p, nil
+		} else if _, err = chunk.Read(check); err != nil {
+			return p, nil
+		}
+
+		if bytes.Equal(check, rootCheck(root)) {
+			p.storedRoot = root
+		} else {
+			p.damagedRoot = true
+		}
+	}
+
+	return p, nil
+}
+
+// forIndex returns the proof hashes if the embedded proof belongs to a chunk with the given index. The
+// number of proof hashes and their sides are fixed by the index and the chunk count. If the embedded proof
+// deviates from them, or ends prematurely, an error wrapping errCorruptHeader, errInvalidSide or
+// errTruncatedProof is returned.
+func (p *embeddedProof) forIndex(index, chunkCount int) ([][]byte, error) {
+	sides, err := merkle.ProofSides(index, chunkCount)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	if p.pathCount != len(sides) {
+		return nil, fmt.Errorf("%w: %d hashes instead of %d", errCorruptHeader, p.pathCount, len(sides))
+	}
+
+	for i, side := range sides {
+		// The side bit is redundant but must match the position of the chunk
+		if i >= len(p.sides) {
+			return nil, fmt.Errorf("%w: side of hash %d is missing", errTruncatedProof, i)
+		} else if p.sides[i] != side {
+			return nil, fmt.Errorf("%w: side of hash %d doesn't match the position", errInvalidSide, i)
+		} else if i >= len(p.hashes) {
+			return nil, fmt.Errorf("%w: hash %d is missing", errTruncatedProof, i)
+		}
+	}
+
+	return p.hashes, nil
 }
 
-// ChunkIndex holds the index of a chunk in the bounds map.
-type ChunkIndex struct {
-	x int
-	y int
+// root returns the Merkle root that results from the given leaf hash and the embedded proof if it belongs to
+// a chunk with the given index (see forIndex). The root of a single chunk is its leaf hash.
+func (p *embeddedProof) root(hasher merkle.Hasher, index, chunkCount int, leaf []byte) ([]byte, error) {
+	proof, err := p.forIndex(index, chunkCount)
+	if err != nil {
+		return nil, err
+	}
+	if chunkCount == 1 && p.damagedRoot {
+		return nil, fmt.Errorf("%w: the stored root doesn't match its check value", errCorruptHeader)
+	} else if chunkCount == 1 && p.storedRoot == nil {
+		return nil, fmt.Errorf("%w: the stored root is missing", errTruncatedProof)
+	}
+	return merkle.RootFromProof(hasher, index, chunkCount, leaf, proof)
 }
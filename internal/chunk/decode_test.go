@@ -0,0 +1,589 @@
+package chunk
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeTestImage encodes a gradient image of the given size with the given options into the given
+// directory and returns the path of the encoded image.
+func encodeTestImage(t *testing.T, dir string, w, h int, opts Options) string {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+
+	filepath := path.Join(dir, "original.png")
+	require.NoError(t, SaveImageFile(filepath, img))
+	require.NoError(t, Encode(filepath, dir, opts))
+
+	// The encoded image replaces the original one as it is saved in the same directory
+	return filepath
+}
+
+// modifyEncodedImage applies the given function to the pixels of the encoded image and saves it again.
+func modifyEncodedImage(t *testing.T, filepath string, modify func(img *image.NRGBA)) {
+	img, opts, err := OpenEncodedImageFile(filepath)
+	require.NoError(t, err)
+	modify(img.(*image.NRGBA))
+	require.NoError(t, SaveEncodedImageFile(filepath, img, opts))
+}
+
+func TestDecode_Clean(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filepath := encodeTestImage(t, dir, 120, 80, DefaultOptions())
+
+	report, err := Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, StatusClean, report.Status)
+	assert.False(t, report.Trusted)
+	assert.Len(t, report.Root, 32)
+	require.Len(t, report.Roots, 1)
+	assert.Equal(t, len(report.Chunks), report.Roots[0].Count)
+	assert.Greater(t, len(report.Chunks), 1)
+	assert.Empty(t, report.Tampered())
+	assert.Nil(t, report.Overlay)
+	assert.Nil(t, report.Signature)
+
+	for i, c := range report.Chunks {
+		assert.Equal(t, i, c.Index)
+		assert.Equal(t, ChunkValid, c.Status)
+		assert.Equal(t, report.Root, c.Root)
+	}
+}
+
+func TestDecode_Logger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filepath := encodeTestImage(t, dir, 120, 80, DefaultOptions())
+
+	var buf bytes.Buffer
+	report, err := Decode(filepath, DecodeOptions{Logger: log.New(&buf, "", 0)})
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, report.Status)
+	assert.Contains(t, buf.String(), "Opening image: "+filepath)
+	assert.Contains(t, buf.String(), "Options: "+DefaultOptions().String())
+}
+
+func TestDecode_Tampered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filepath := encodeTestImage(t, dir, 120, 80, DefaultOptions())
+	clean, err := Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+
+	// Change the content of the top left pixel
+	modifyEncodedImage(t, filepath, func(img *image.NRGBA) { img.Pix[0] ^= 0x80 })
+
+	report, err := Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, StatusTampered, report.Status)
+	assert.Equal(t, clean.Root, report.Root)
+	require.Len(t, report.Roots, 2)
+
+	tampered := report.Tampered()
+	require.Len(t, tampered, 1)
+	assert.Equal(t, 0, tampered[0].Index)
+	assert.Equal(t, ChunkRootMismatch, tampered[0].Status)
+	assert.True(t, image.Pt(0, 0).In(tampered[0].Bounds))
+	assert.NotNil(t, report.Overlay)
+}
+
+func TestDecode_TrustedRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filepath := encodeTestImage(t, dir, 120, 80, DefaultOptions())
+	clean, err := Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+
+	report, err := Decode(filepath, DecodeOptions{Root: clean.Root})
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, report.Status)
+	assert.True(t, report.Trusted)
+
+	other := make([]byte, len(clean.Root))
+	report, err = Decode(filepath, DecodeOptions{Root: other})
+	require.NoError(t, err)
+	assert.Equal(t, StatusNoTrustedMatch, report.Status)
+	assert.Equal(t, other, report.Root)
+	assert.Len(t, report.Tampered(), len(report.Chunks))
+
+	_, err = Decode(filepath, DecodeOptions{Root: other[:4]})
+	assert.Error(t, err)
+}
+
+func TestDecode_Signature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = GenerateKeyPair(dir, "alice")
+	require.NoError(t, err)
+	key, err := ReadSigningKey(path.Join(dir, "alice.key"))
+	require.NoError(t, err)
+	trustedKeys, err := ReadTrustedKeys(path.Join(dir, "alice.pub"))
+	require.NoError(t, err)
+
+	opts := DefaultOptions()
+	opts.SigningKey = key
+	filepath := encodeTestImage(t, dir, 200, 150, opts)
+
+	report, err := Decode(filepath, DecodeOptions{TrustedKeys: trustedKeys})
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, report.Status)
+	require.NotNil(t, report.Signature)
+	assert.True(t, report.Signature.Recovered)
+	assert.True(t, report.Signature.Verified)
+	assert.True(t, report.Signature.Required)
+	assert.Equal(t, "alice", report.Signature.Signer)
+
+	report, err = Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, report.Status)
+	require.NotNil(t, report.Signature)
+	assert.True(t, report.Signature.Recovered)
+	assert.False(t, report.Signature.Verified)
+	assert.False(t, report.Signature.Required)
+	assert.Empty(t, report.Signature.Signer)
+
+	// A root signed by a key that isn't trusted fails the verification
+	_, err = GenerateKeyPair(dir, "bob")
+	require.NoError(t, err)
+	otherKeys, err := ReadTrustedKeys(path.Join(dir, "bob.pub"))
+	require.NoError(t, err)
+	report, err = Decode(filepath, DecodeOptions{TrustedKeys: otherKeys})
+	require.NoError(t, err)
+	assert.Equal(t, StatusUntrustedSignature, report.Status)
+	require.NotNil(t, report.Signature)
+	assert.True(t, report.Signature.Recovered)
+	assert.False(t, report.Signature.Verified)
+	assert.Empty(t, report.Signature.Signer)
+
+	// So does an image that has been encoded again without signing it
+	filepath = encodeTestImage(t, dir, 200, 150, DefaultOptions())
+	report, err = Decode(filepath, DecodeOptions{TrustedKeys: trustedKeys})
+	require.NoError(t, err)
+	assert.Equal(t, StatusUntrustedSignature, report.Status)
+	require.NotNil(t, report.Signature)
+	assert.False(t, report.Signature.Recovered)
+	assert.False(t, report.Signature.Verified)
+	assert.True(t, report.Signature.Required)
+
+	report, err = Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, report.Status)
+	assert.Nil(t, report.Signature)
+}
+
+func TestDecode_HMAC(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := DefaultOptions()
+	opts.MACKey = []byte("secret")
+	filepath := encodeTestImage(t, dir, 120, 80, opts)
+
+	_, err = Decode(filepath, DecodeOptions{})
+	assert.Error(t, err)
+
+	report, err := Decode(filepath, DecodeOptions{MACKey: []byte("secret")})
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, report.Status)
+	assert.Nil(t, report.Options.MACKey)
+
+	// With the wrong key every chunk leads to a root of its own, none of which is chosen
+	report, err = Decode(filepath, DecodeOptions{MACKey: []byte("wrong")})
+	require.NoError(t, err)
+	assert.Equal(t, StatusNoCommonRoot, report.Status)
+	assert.Nil(t, report.Root)
+	assert.Len(t, report.Roots, len(report.Chunks))
+	assert.Nil(t, report.Overlay)
+	for _, c := range report.Chunks {
+		assert.NotEqual(t, ChunkValid, c.Status)
+		assert.Equal(t, TamperUnknown, c.Tamper)
+	}
+}
+
+func TestDecode_WrongKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := DefaultOptions()
+	opts.Key = []byte("secret")
+	filepath := encodeTestImage(t, dir, 120, 80, opts)
+	clean, err := Decode(filepath, DecodeOptions{Key: []byte("secret")})
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, clean.Status)
+
+	report, err := Decode(filepath, DecodeOptions{Key: []byte("wrong")})
+	require.NoError(t, err)
+	assert.Equal(t, StatusNoCommonRoot, report.Status)
+	assert.Nil(t, report.Root)
+	assert.Nil(t, report.Overlay)
+
+	// A trusted root doesn't change that
+	report, err = Decode(filepath, DecodeOptions{Key: []byte("wrong"), Root: clean.Root})
+	require.NoError(t, err)
+	assert.Equal(t, StatusNoCommonRoot, report.Status)
+}
+
+func TestDecode_NotEncoded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Random pixels as identical chunks of a plain image would agree on a root
+	img := image.NewNRGBA(image.Rect(0, 0, 120, 80))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	filepath := path.Join(dir, "plain.png")
+	require.NoError(t, SaveImageFile(filepath, img))
+
+	report, err := Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, StatusNotEncoded, report.Status)
+	assert.Nil(t, report.Root)
+	assert.Nil(t, report.Overlay)
+
+	// An image that is too small to be encoded is read as a single chunk of the first release
+	img = image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	rand.New(rand.NewSource(1)).Read(img.Pix)
+	require.NoError(t, SaveImageFile(filepath, img))
+
+	report, err = Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, StatusNotEncoded, report.Status)
+	assert.Len(t, report.Chunks, 1)
+	assert.Nil(t, report.Overlay)
+}
+
+func TestEmbeddedProof_Reasons(t *testing.T) {
+	opts := DefaultOptions()
+
+	// newChunk returns the first of four chunks of the given size with the given number of hashes and sides
+	// written to it, every side followed by as much of a proof hash as fits
+	newChunk := func(size int, pathCount uint64, sides ...bool) *Chunk {
+		img := blackImage(size, size)
+		w := &Chunk{Image: img}
+		require.NoError(t, w.WriteBits(pathCount, uint8(PathCountBitLength(4))))
+		for _, side := range sides {
+			require.NoError(t, w.WriteBool(side))
+			_, _ = w.Write(make([]byte, opts.ProofHashBitLength()/BitsPerByte))
+		}
+		return &Chunk{Image: img}
+	}
+
+	// chunkRoot returns the root of the embedded proof of the given first chunk
+	chunkRoot := func(chunk *Chunk) ([]byte, error) {
+		proof, err := readEmbeddedProof(chunk, opts, 4)
+		if err != nil {
+			return nil, err
+		}
+		return proof.root(opts.merkleHasher(), 0, 4, make([]byte, 32))
+	}
+
+	root, err := chunkRoot(newChunk(16, 2, true, true))
+	require.NoError(t, err)
+	assert.Len(t, root, 32)
+
+	tests := []struct {
+		name  string
+		chunk *Chunk
+		want  ChunkStatus
+	}{
+		{name: "corrupt header", chunk: newChunk(16, 3, true, true), want: ChunkCorruptHeader},
+		{name: "invalid side flag", chunk: newChunk(16, 2, true, false), want: ChunkInvalidSide},
+		// Two hashes don't fit into the 3*12*12 LSBs of a 12x12 chunk
+		{name: "truncated proof", chunk: newChunk(12, 2, true, true), want: ChunkTruncatedProof},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := chunkRoot(tt.chunk)
+			status, ok := proofErrorStatus(err)
+			assert.True(t, ok)
+			assert.Equal(t, tt.want, status)
+		})
+	}
+}
+
+func TestDecode_Tamper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filepath := encodeTestImage(t, dir, 120, 80, DefaultOptions())
+	clean, err := Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+
+	// Encode another image of the same size to transplant a chunk from
+	other, _, err := OpenEncodedImageFile(filepath)
+	require.NoError(t, err)
+	for i := range other.(*image.NRGBA).Pix {
+		other.(*image.NRGBA).Pix[i] ^= 0x80
+	}
+	otherDir := path.Join(dir, "other")
+	require.NoError(t, os.Mkdir(otherDir, 0755))
+	otherFilepath := path.Join(otherDir, "other.png")
+	require.NoError(t, SaveImageFile(otherFilepath, other))
+	require.NoError(t, Encode(otherFilepath, otherDir, DefaultOptions()))
+	other, _, err = OpenEncodedImageFile(otherFilepath)
+	require.NoError(t, err)
+
+	// Find a chunk that can be moved to the position of another one of the same size
+	from, to := -1, -1
+	for i := 4; i < len(clean.Chunks) && to < 0; i++ {
+		for j := i + 1; j < len(clean.Chunks); j++ {
+			if clean.Chunks[i].Bounds.Size() == clean.Chunks[j].Bounds.Size() {
+				from, to = i, j
+				break
+			}
+		}
+	}
+	require.True(t, to > 0)
+
+	copyChunk := func(dst, src *image.NRGBA, to, from image.Rectangle) {
+		for y := 0; y < from.Dy(); y++ {
+			for x := 0; x < from.Dx(); x++ {
+				copy(dst.Pix[dst.PixOffset(to.Min.X+x, to.Min.Y+y):][:4], src.Pix[src.PixOffset(from.Min.X+x, from.Min.Y+y):][:4])
+			}
+		}
+	}
+
+	modifyEncodedImage(t, filepath, func(img *image.NRGBA) {
+		// Content of chunk 0, payload of chunk 1, chunks 2 and 3 from the other image
+		img.Pix[0] ^= 0x80
+		b := clean.Chunks[1].Bounds
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				img.Pix[img.PixOffset(x, y)] &^= 1
+				img.Pix[img.PixOffset(x, y)+1] &^= 1
+				img.Pix[img.PixOffset(x, y)+2] &^= 1
+			}
+		}
+		copyChunk(img, other.(*image.NRGBA), clean.Chunks[2].Bounds, clean.Chunks[2].Bounds)
+		copyChunk(img, other.(*image.NRGBA), clean.Chunks[3].Bounds, clean.Chunks[3].Bounds)
+		copyChunk(img, img, clean.Chunks[to].Bounds, clean.Chunks[from].Bounds)
+	})
+
+	report, err := Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+	require.Equal(t, StatusTampered, report.Status)
+
+	assert.Equal(t, TamperContent, report.Chunks[0].Tamper)
+	assert.Equal(t, TamperPayload, report.Chunks[1].Tamper)
+	assert.Equal(t, TamperTransplanted, report.Chunks[2].Tamper)
+	assert.Equal(t, TamperTransplanted, report.Chunks[3].Tamper)
+	assert.Equal(t, TamperMoved, report.Chunks[to].Tamper)
+	assert.Equal(t, from, report.Chunks[to].MovedFrom)
+	assert.Equal(t, TamperNone, report.Chunks[from].Tamper)
+	assert.Len(t, report.Tampered(), 5)
+
+	// The overlay marks the chunks in the color of their manipulation, not of their reason
+	img, _, err := OpenEncodedImageFile(filepath)
+	require.NoError(t, err)
+	overlay := ImageToRGBA(img)
+	colors := map[int]color.RGBA{
+		0:  {R: 255, A: 255},
+		1:  {R: 255, G: 255, A: 255},
+		2:  {R: 255, B: 255, A: 255},
+		3:  {R: 255, B: 255, A: 255},
+		to: {B: 255, A: 255},
+	}
+	for i, clr := range colors {
+		drawOverlay(overlay, clean.Chunks[i].Bounds, clr, 80)
+	}
+	assert.Equal(t, overlay.Pix, report.Overlay.Pix)
+}
+
+func TestDecode_SubBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := DefaultOptions()
+	opts.SubBlocks = 4
+	filepath := encodeTestImage(t, dir, 200, 150, opts)
+	clean, err := Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, clean.Status)
+
+	// Change the content of a pixel in the middle of the first chunk
+	bounds := clean.Chunks[0].Bounds
+	pt := image.Pt(bounds.Dx()/2, bounds.Dy()/2)
+	modifyEncodedImage(t, filepath, func(img *image.NRGBA) { img.Pix[img.PixOffset(pt.X, pt.Y)] ^= 0x80 })
+
+	report, err := Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+	require.Equal(t, StatusTampered, report.Status)
+
+	c := report.Chunks[0]
+	assert.Equal(t, TamperContent, c.Tamper)
+	require.Len(t, c.Changed, 1)
+	assert.True(t, pt.In(c.Changed[0]))
+	assert.True(t, c.Changed[0].In(bounds))
+	assert.NotEqual(t, bounds, c.Changed[0])
+}
+
+func TestDecode_SingleChunk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := DefaultOptions()
+	opts.SubBlocks = 2
+	filepath := encodeTestImage(t, dir, 12, 12, opts)
+	clean, err := Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, clean.Status)
+	require.Len(t, clean.Chunks, 1)
+	assert.Equal(t, ChunkValid, clean.Chunks[0].Status)
+	assert.Len(t, clean.Root, 32)
+
+	// The single chunk is judged against the root it stores
+	modifyEncodedImage(t, filepath, func(img *image.NRGBA) {
+		img.Pix[0] ^= 0x80
+	})
+
+	report, err := Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, StatusTampered, report.Status)
+	assert.Equal(t, clean.Root, report.Root)
+	require.Len(t, report.Tampered(), 1)
+	assert.Equal(t, ChunkRootMismatch, report.Chunks[0].Status)
+	assert.Equal(t, TamperContent, report.Chunks[0].Tamper)
+	assert.Equal(t, []image.Rectangle{image.Rect(0, 0, 6, 6)}, report.Chunks[0].Changed)
+
+	// A trusted root still takes precedence
+	report, err = Decode(filepath, DecodeOptions{Root: report.Chunks[0].Root})
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, report.Status)
+
+	// A damaged stored root doesn't match its check value, so the content can't be judged against it and the
+	// manipulation can't be classified
+	modifyEncodedImage(t, filepath, func(img *image.NRGBA) {
+		img.Pix[0] ^= 0x80
+		for i := 1; i < 5; i++ {
+			img.Pix[img.PixOffset(i, 0)] ^= 1
+		}
+	})
+
+	report, err = Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, StatusTampered, report.Status)
+	assert.Nil(t, report.Root)
+	assert.Equal(t, ChunkCorruptHeader, report.Chunks[0].Status)
+	assert.Equal(t, TamperUnknown, report.Chunks[0].Tamper)
+	assert.Empty(t, report.Chunks[0].Changed)
+}
+
+func TestDecode_DCT(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := DefaultOptions()
+	opts.DCT = true
+	encodeTestImage(t, dir, 160, 80, opts)
+	filepath := path.Join(dir, "original.jpg")
+
+	report, err := Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, report.Status)
+
+	// Saving the image as a JPEG again drops the options and requantizes the coefficients, the embedded data
+	// doesn't survive that
+	img, _, err := OpenImageFile(filepath)
+	require.NoError(t, err)
+	file, err := os.Create(filepath)
+	require.NoError(t, err)
+	require.NoError(t, jpeg.Encode(file, img, &jpeg.Options{Quality: opts.Quality}))
+	require.NoError(t, file.Close())
+
+	report, err = Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, StatusNotEncoded, report.Status)
+}
+
+func TestDecode_FixedLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// The grid is recorded in the image so the decoder doesn't need it
+	opts := DefaultOptions()
+	opts.Columns, opts.Rows = 3, 2
+	filepath := encodeTestImage(t, dir, 200, 150, opts)
+	report, err := Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, report.Status)
+	require.Len(t, report.Chunks, 6)
+	assert.Equal(t, image.Rect(134, 75, 200, 150), report.Chunks[5].Bounds)
+}
+
+func TestDecode_Levels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	opts := DefaultOptions()
+	opts.Levels = 2
+	filepath := encodeTestImage(t, dir, 200, 150, opts)
+	clean, err := Decode(filepath, DecodeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, clean.Status)
+
+	coarse, fine := 0, 0
+	for _, c := range clean.Chunks {
+		if c.Level == 0 {
+			coarse++
+		} else {
+			fine++
+		}
+	}
+	assert.Greater(t, coarse, 1)
+	assert.Greater(t, fine, coarse)
+
+	// Destroying the least significant bits only damages the payload of the fine chunks, the coarse chunks
+	// tell that the content is intact
+	modifyEncodedImage(t, filepath, func(img *image.NRGBA) {
+		for i := range img.Pix {
+			if i%4 != 3 {
+				img.Pix[i] &^= 1
+			}
+		}
+	})
+
+	report, err := Decode(filepath, DecodeOptions{Root: clean.Root})
+	require.NoError(t, err)
+	assert.Equal(t, StatusTampered, report.Status)
+	for _, c := range report.Chunks {
+		if c.Level == 0 {
+			assert.Equal(t, ChunkValid, c.Status)
+		} else {
+			assert.Equal(t, TamperPayload, c.Tamper)
+		}
+	}
+}
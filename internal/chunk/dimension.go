@@ -1,34 +1,146 @@
 package chunk
 
 import (
+	"fmt"
 	"image"
 	"math"
+	"math/bits"
+
+	"dennis-tra/image-stego/pkg/jpegdct"
 )
 
-// CalculateChunkBounds takes the given *image.RGBA and calculates the optimal distribution of image chunks
-// to encode the merkle tree data.
+// CalculateChunkBounds takes the given Image and calculates the optimal distribution of image chunks
+// to encode the merkle tree data into the channels and depth selected by the given options.
 //
 // The more chunks we anticipate the smaller they become, the more of them are there and the more data needs
 // to be encoded in each chunk to store all the merkle tree data. So there is an optimum of the number of chunks.
 // Basically we want the highest number of chunks where each individual one can still store all the necessary
-// merkle information.
-//
-// The calculation is an iterative process. The calculation starts with the assumption that we want to use
-// two chunks to encode the data. First it calculates the required amount of bits to encode all merkle nodes
-// within one chunk. Then it calculates the total number of available bits per chunk. In the first iteration
-// the number of available bits will usually be much larger than the required bits.
-//
-// If the amount of required bits exceeds the available least significant bits we stop and are sure we have found
-// the maximum number of chunks that this image can be divided into.
-//
-// Beware that with one merkle tree leaf hash (256 bits) the side of the merkle node (1 byte) needs to be encoded
-// and the number of leaf nodes (offset of 8) as well.
-//
-// As a last step we built a matrix of bounds that represent the chunks in the given image. Since the chunks may
-// not divide the side lengths perfectly we need to handle the clipping as well.
-func CalculateChunkBounds(rgba *image.RGBA) [][]image.Rectangle {
+// merkle information and that are close to square (see solveLayout). In the quadtree layout (see Options.Levels)
+// the chunks of all levels count.
+func CalculateChunkBounds(img Image, opts Options) [][]image.Rectangle {
+
+	chunk := Chunk{Image: img, Channels: opts.Channels, Depth: opts.Depth, Hash: opts.Hash}
+
+	// The number of bits of each stored hash. It may be truncated (see Options.ProofHashBits).
+	hashBits := chunk.hash().BitLength()
+	if opts.ProofHashBits > 0 {
+		hashBits = opts.ProofHashBits
+	}
+
+	// The available amount of bits of each pixel
+	pixelBits := len(chunk.payloadOffsets()) * chunk.depth()
+
+	chunkCountX, chunkCountY := solveLayout(chunk.Width(), chunk.Height(), pixelBits, hashBits, opts.levels())
+	return gridBounds(chunk.Width(), chunk.Height(), chunkCountX, chunkCountY)
+}
+
+// neededProofBits returns the number of bits that are needed to store a Merkle proof of a tree with the given
+// number of leaves (chunks) whose hashes are truncated to the given number of bits.
+func neededProofBits(treeSize, hashBits int) int {
+	hashesPerChunk := int(math.Ceil(math.Log2(float64(treeSize))))
+	return hashesPerChunk*(hashBits+MerkleSideBitLength) + PathCountBitLength(treeSize)
+}
+
+// layoutFits returns true if every chunk of a grid of the given number of columns and rows over an image of
+// the given width and height has enough bits to store its proof.
+func layoutFits(width, height, cols, rows, pixelBits, hashBits, levels int) bool {
+	// guaranteed width and height of each chunk (could be more due to clipping)
+	chunkWidth := width / cols
+	chunkHeight := height / rows
+
+	return chunkWidth*chunkHeight*pixelBits >= neededProofBits(layoutSize(cols, rows, levels), hashBits)
+}
+
+// maxChunkAspect is the maximum ratio of the longer and the shorter side of the chunks that solveLayout
+// accepts to fit more chunks into an image, unless the image itself is too narrow.
+const maxChunkAspect = 1.5
+
+// solveLayout returns the number of columns and rows of the grid with the most chunks that still hold their
+// proofs and whose sides differ by no more than maxChunkAspect. So a panorama gets more columns than rows
+// instead of the strips a prime number of chunks would give. Of two grids with the same number of chunks the
+// one with the squarer chunks wins. Images that are too narrow for such chunks get a single row or column,
+// and if no grid within the aspect limit beats a single chunk, the grid with the most chunks regardless of
+// their shape is chosen. If not even two chunks fit, the whole image is a single chunk that stores the root
+// instead of a proof (see requiredBits).
+func solveLayout(width, height, pixelBits, hashBits, levels int) (int, int) {
+	cols, rows := searchLayout(width, height, pixelBits, hashBits, levels, maxChunkAspect)
+	if cols*rows == 1 {
+		cols, rows = searchLayout(width, height, pixelBits, hashBits, levels, math.Inf(1))
+	}
+	return cols, rows
+}
+
+// searchLayout returns the grid with the most chunks whose sides differ by no more than the given aspect
+// ratio (see solveLayout). The grids are searched column by column and row by row, so that wide and tall
+// images are treated alike.
+func searchLayout(width, height, pixelBits, hashBits, levels int, aspect float64) (int, int) {
+	bestX, bestY := 1, 1
+	bestSkew := chunkSkew(width, height, 1, 1)
+
+	for cols := 1; cols <= width; cols++ {
+		rows := searchRows(width, height, cols, pixelBits, hashBits, levels, aspect)
+		if skew := chunkSkew(width, height, cols, rows); rows > 0 && (cols*rows > bestX*bestY || (cols*rows == bestX*bestY && skew < bestSkew)) {
+			bestX, bestY, bestSkew = cols, rows, skew
+		}
+	}
 
-	chunk := Chunk{RGBA: rgba}
+	// The same search along the other axis
+	for rows := 1; rows <= height; rows++ {
+		cols := searchRows(height, width, rows, pixelBits, hashBits, levels, aspect)
+		if skew := chunkSkew(width, height, cols, rows); cols > 0 && (cols*rows > bestX*bestY || (cols*rows == bestX*bestY && skew < bestSkew)) {
+			bestX, bestY, bestSkew = cols, rows, skew
+		}
+	}
+
+	return bestX, bestY
+}
+
+// searchRows returns the most rows of a grid with the given number of columns whose chunks hold their proofs
+// and whose sides differ by no more than the given aspect ratio. A single row is always considered, so that
+// narrow images are divided nevertheless. It returns zero if no number of rows fits.
+func searchRows(width, height, cols, pixelBits, hashBits, levels int, aspect float64) int {
+	// The number of rows that makes the chunks exactly square and the range of rows whose chunks are
+	// close enough to square
+	exact := float64(cols*height) / float64(width)
+	lo := int(math.Ceil(exact / aspect))
+	if lo < 1 {
+		lo = 1
+	}
+	hi := height
+	if limit := math.Floor(exact * aspect); limit < float64(height) {
+		hi = int(limit)
+	}
+	if hi < lo {
+		hi = lo
+	}
+
+	// The more rows the smaller the chunks and the larger the tree, so search the most rows that fit
+	if lo > height || !layoutFits(width, height, cols, lo, pixelBits, hashBits, levels) {
+		return 0
+	}
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if layoutFits(width, height, cols, mid, pixelBits, hashBits, levels) {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	return lo
+}
+
+// chunkSkew returns how far the chunks of the given grid deviate from a square. It is zero for square chunks
+// and grows with the ratio of their longer and shorter side regardless of their orientation.
+func chunkSkew(width, height, cols, rows int) float64 {
+	ratio := (float64(width) / float64(cols)) / (float64(height) / float64(rows))
+	return math.Abs(math.Log(ratio))
+}
+
+// legacyLayout returns the number of columns and rows of the grid of the first release (see v0.go). It tries
+// even chunk counts until the chunks can't hold their proofs anymore and distributes the last working count
+// over the columns and rows by its prime factors (see chunkDist) without regard to the image dimensions.
+func legacyLayout(width, height int) (int, int) {
 
 	// Calculate maximum number of chunks that this image can be divided into taken into account
 	chunkCount := 0
@@ -37,48 +149,47 @@ func CalculateChunkBounds(rgba *image.RGBA) [][]image.Rectangle {
 		// neededBitsPerChunk answers the question: How many bits do we need to store the merkle tree leaves if
 		// we had chunkCount many chunks. The more chunks -> the more merkle leaves -> the less data can be saved
 		// into one chunk.
-
-		// The number of hashes that need to be saved into each chunk based on the total chunk count.
-		hashesPerChunk := int(math.Ceil(math.Log2(float64(chunkCount))))
-		neededBitsPerChunk := hashesPerChunk*(HashBitLength+MerkleSideBitLength) + PathCountBitLength
-
 		chunkCountX, chunkCountY := chunkDist(chunkCount)
 
-		// guaranteed width and height of each chunk (could be more due to clipping
-		chunkWidth := chunk.Width() / chunkCountX
-		chunkHeight := chunk.Height() / chunkCountY
-
-		// The available amount of bits in each chunk
-		availableBitsPerChunk := chunkWidth * chunkHeight * 3
-
 		// If we need more bits than are available we stop and decrement the chunk count to the last
 		// "working" count.
-		if neededBitsPerChunk > availableBitsPerChunk {
+		if v0NeededBits(chunkCount) > (width/chunkCountX)*(height/chunkCountY)*v0PixelBits {
 			chunkCount -= 2
 			break
 		}
 	}
 
+	// Images that are too small for two chunks have never been encoded with this layout, chunkDist can't
+	// distribute zero chunks.
+	if chunkCount == 0 {
+		return 1, 1
+	}
+
 	// Calculate the number of chunks along the width and height
-	chunkCountX, chunkCountY := chunkDist(chunkCount)
+	return chunkDist(chunkCount)
+}
+
+// gridBounds divides an image of the given width and height into a grid of the given number of columns and
+// rows. The side lengths of the chunks differ by one at most.
+func gridBounds(width, height, cols, rows int) [][]image.Rectangle {
 
 	// guaranteed width and height of each chunk
-	chunkWidth := chunk.Width() / chunkCountX
-	chunkHeight := chunk.Height() / chunkCountY
+	chunkWidth := width / cols
+	chunkHeight := height / rows
 
 	// Add clippings (the side length to chunk count ratio will likely be rational so we add the remainder to the
 	// side lengths equally.
-	chunkWidthClippings := chunk.Width() % chunkCountX
-	chunkHeightClippings := chunk.Height() % chunkCountY
+	chunkWidthClippings := width % cols
+	chunkHeightClippings := height % rows
 
-	bounds := make([][]image.Rectangle, chunkCountX)
+	bounds := make([][]image.Rectangle, cols)
 	for i := range bounds {
-		bounds[i] = make([]image.Rectangle, chunkCountY)
+		bounds[i] = make([]image.Rectangle, rows)
 	}
 
 	cxOff := 0
 	cyOff := 0
-	for cx := 0; cx < chunkCountX; cx++ {
+	for cx := 0; cx < cols; cx++ {
 
 		cw := chunkWidth
 		if cx < chunkWidthClippings {
@@ -88,7 +199,7 @@ func CalculateChunkBounds(rgba *image.RGBA) [][]image.Rectangle {
 			cxOff = chunkWidthClippings
 		}
 
-		for cy := 0; cy < chunkCountY; cy++ {
+		for cy := 0; cy < rows; cy++ {
 
 			ch := chunkHeight
 			if cy < chunkHeightClippings {
@@ -105,6 +216,160 @@ func CalculateChunkBounds(rgba *image.RGBA) [][]image.Rectangle {
 	return bounds
 }
 
+// sizeBounds divides an image of the given width and height into chunks of the given size. The chunks at the
+// right and bottom edges are smaller if the size doesn't divide the image.
+func sizeBounds(width, height, chunkWidth, chunkHeight int) [][]image.Rectangle {
+	cols := (width + chunkWidth - 1) / chunkWidth
+	rows := (height + chunkHeight - 1) / chunkHeight
+
+	bounds := make([][]image.Rectangle, cols)
+	for cx := range bounds {
+		bounds[cx] = make([]image.Rectangle, rows)
+		for cy := range bounds[cx] {
+			bounds[cx][cy] = image.Rect(cx*chunkWidth, cy*chunkHeight, (cx+1)*chunkWidth, (cy+1)*chunkHeight).
+				Intersect(image.Rect(0, 0, width, height))
+		}
+	}
+	return bounds
+}
+
+// fixedBounds returns the grid of chunks that is fixed by the options (see Options.Columns and
+// Options.ChunkWidth) or nil if the grid is searched automatically. It returns an error if the image is too
+// small for the grid.
+func fixedBounds(img Image, opts Options) ([][]image.Rectangle, error) {
+	chunk := Chunk{Image: img, Channels: opts.Channels, Depth: opts.Depth}
+
+	var bounds [][]image.Rectangle
+	switch {
+	case opts.Columns > 0:
+		if opts.Columns > chunk.Width() || opts.Rows > chunk.Height() {
+			return nil, fmt.Errorf("the image of %dx%d pixels is too small for a grid of %dx%d chunks", chunk.Width(), chunk.Height(), opts.Columns, opts.Rows)
+		}
+		bounds = gridBounds(chunk.Width(), chunk.Height(), opts.Columns, opts.Rows)
+	case opts.ChunkWidth > 0:
+		// The pixels of DCT coefficient images are blocks
+		width, height := opts.ChunkWidth, opts.ChunkHeight
+		if _, ok := img.(*jpegdct.Image); ok {
+			if width%jpegdct.BlockSize != 0 || height%jpegdct.BlockSize != 0 {
+				return nil, fmt.Errorf("the chunk size %dx%d must be a multiple of the %d pixel DCT blocks", width, height, jpegdct.BlockSize)
+			}
+			width, height = width/jpegdct.BlockSize, height/jpegdct.BlockSize
+		}
+		bounds = sizeBounds(chunk.Width(), chunk.Height(), width, height)
+	default:
+		return nil, nil
+	}
+
+	return bounds, nil
+}
+
+// requiredBits returns the number of payload bits that every chunk of a layout with the given total number of
+// chunks must hold: the longest Merkle proof or, if the image is a single chunk without a proof, the root itself
+// and its check value.
+func requiredBits(treeSize int, opts Options) int {
+	if treeSize == 1 {
+		return opts.Hash.BitLength() + RootCheckBitLength
+	}
+	return neededProofBits(treeSize, opts.ProofHashBitLength())
+}
+
+// checkCapacity returns an error if a chunk of the given finest grid can't hold its payload (see requiredBits).
+// The automatic layout only fails for images that are too small for even a single chunk, the error tells the
+// minimum dimensions then.
+func checkCapacity(img Image, bounds [][]image.Rectangle, opts Options) error {
+	neededBits := requiredBits(layoutSize(len(bounds), len(bounds[0]), opts.levels()), opts)
+
+	for _, row := range bounds {
+		for _, b := range row {
+			c := Chunk{Image: img.SubImage(b).(Image), Channels: opts.Channels, Depth: opts.Depth}
+			if c.LSBCount() >= neededBits {
+				continue
+			}
+
+			size := pixelRect(img, b).Size()
+			if opts.fixedLayout() {
+				auto := CalculateChunkBounds(img, opts)
+				return fmt.Errorf("the grid of %dx%d chunks can't hold the Merkle proofs: a chunk of %dx%d pixels has %d payload bits "+
+					"but a proof takes %d, the automatic layout has %dx%d chunks", len(bounds), len(bounds[0]), size.X, size.Y,
+					c.LSBCount(), neededBits, len(auto), len(auto[0]))
+			}
+
+			// The smallest square image whose single chunk (of each level) holds its payload
+			pixelBits := len(c.payloadOffsets()) * c.depth()
+			pixels := (neededBits + pixelBits - 1) / pixelBits
+			side := int(math.Ceil(math.Sqrt(float64(pixels))))
+			if _, ok := img.(*jpegdct.Image); ok {
+				side *= jpegdct.BlockSize
+			}
+			return fmt.Errorf("the image of %dx%d pixels is too small: it has %d payload bits but at least %d are needed, "+
+				"e.g. with %dx%d pixels", size.X, size.Y, c.LSBCount(), neededBits, side, side)
+		}
+	}
+
+	return nil
+}
+
+// CalculateLevelBounds lays out the chunks of the given image in the levels of a quadtree (see Options.Levels),
+// the coarsest level first. The finest level is the grid that is fixed by the options or otherwise the grid of
+// CalculateChunkBounds, every coarser level merges 2x2 chunks of the next finer one. The chunks at the right
+// and bottom edges merge fewer chunks if the number of columns or rows is odd. Without levels the result is
+// the single finest grid. An error is returned if a fixed grid doesn't fit the image or if the image is too small
+// for a single chunk.
+func CalculateLevelBounds(img Image, opts Options) ([][][]image.Rectangle, error) {
+	fine, err := fixedBounds(img, opts)
+	if err != nil {
+		return nil, err
+	} else if fine == nil {
+		fine = CalculateChunkBounds(img, opts)
+	}
+
+	if err = checkCapacity(img, fine, opts); err != nil {
+		return nil, err
+	}
+
+	levels := make([][][]image.Rectangle, opts.levels())
+	levels[len(levels)-1] = fine
+	for l := len(levels) - 2; l >= 0; l-- {
+		levels[l] = mergeBounds(levels[l+1])
+	}
+	return levels, nil
+}
+
+// mergeBounds merges 2x2 neighbouring chunk bounds into one.
+func mergeBounds(bounds [][]image.Rectangle) [][]image.Rectangle {
+	merged := make([][]image.Rectangle, (len(bounds)+1)/2)
+	for cx := range merged {
+		merged[cx] = make([]image.Rectangle, (len(bounds[0])+1)/2)
+		for cy := range merged[cx] {
+			for x := 2 * cx; x < 2*cx+2 && x < len(bounds); x++ {
+				for y := 2 * cy; y < 2*cy+2 && y < len(bounds[x]); y++ {
+					merged[cx][cy] = merged[cx][cy].Union(bounds[x][y])
+				}
+			}
+		}
+	}
+	return merged
+}
+
+// layoutSize returns the total number of chunks of all levels of a quadtree layout whose finest level has the
+// given number of columns and rows.
+func layoutSize(cols, rows, levels int) int {
+	size := 0
+	for l := 0; l < levels; l++ {
+		size += cols * rows
+		cols, rows = (cols+1)/2, (rows+1)/2
+	}
+	return size
+}
+
+// PathCountBitLength returns the number of bits occupied by the information of how many merkle tree
+// hashes are encoded in each chunk if the image is divided into chunkCount chunks. A chunk never holds
+// more than log2(chunkCount) hashes, so only as many bits as are needed to represent that number are used.
+func PathCountBitLength(chunkCount int) int {
+	hashesPerChunk := int(math.Ceil(math.Log2(float64(chunkCount))))
+	return bits.Len(uint(hashesPerChunk))
+}
+
 // chunkDist calculates the chunk distribution along the width and height.
 // The aim is to get an evenly distributed field of chunks.
 func chunkDist(count int) (int, int) {
@@ -0,0 +1,183 @@
+package chunk
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalculateLevelBounds(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Levels = 3
+	img := blackImage(300, 200)
+
+	levels, err := CalculateLevelBounds(img, opts)
+	require.NoError(t, err)
+	require.Len(t, levels, 3)
+
+	for l, bounds := range levels {
+		// Every level covers the whole image without overlaps
+		area := 0
+		for _, row := range bounds {
+			for _, b := range row {
+				assert.True(t, b.In(img.Bounds()))
+				area += b.Dx() * b.Dy()
+			}
+		}
+		assert.Equal(t, 300*200, area, l)
+
+		// Every chunk of a coarser level is made of up to 2x2 chunks of the next finer one
+		if l > 0 {
+			assert.Equal(t, (len(bounds)+1)/2, len(levels[l-1]))
+			assert.Equal(t, (len(bounds[0])+1)/2, len(levels[l-1][0]))
+			assert.True(t, bounds[1][1].In(levels[l-1][0][0]))
+		}
+	}
+
+	opts.Levels = 0
+	levels, err = CalculateLevelBounds(img, opts)
+	require.NoError(t, err)
+	assert.Len(t, levels, 1)
+}
+
+func TestCalculateLevelBounds_Fixed(t *testing.T) {
+	img := blackImage(300, 200)
+
+	opts := DefaultOptions()
+	opts.Columns, opts.Rows = 4, 3
+	levels, err := CalculateLevelBounds(img, opts)
+	require.NoError(t, err)
+	require.Len(t, levels, 1)
+	require.Len(t, levels[0], 4)
+	require.Len(t, levels[0][0], 3)
+	assert.Equal(t, image.Rect(0, 0, 75, 67), levels[0][0][0])
+	assert.Equal(t, image.Rect(225, 134, 300, 200), levels[0][3][2])
+
+	// The chunks at the edges are clipped
+	opts = DefaultOptions()
+	opts.ChunkWidth, opts.ChunkHeight = 128, 64
+	opts.Levels = 2
+	levels, err = CalculateLevelBounds(img, opts)
+	require.NoError(t, err)
+	require.Len(t, levels, 2)
+	require.Len(t, levels[1], 3)
+	require.Len(t, levels[1][0], 4)
+	assert.Equal(t, image.Rect(128, 64, 256, 128), levels[1][1][1])
+	assert.Equal(t, image.Rect(256, 192, 300, 200), levels[1][2][3])
+	assert.Equal(t, image.Rect(0, 0, 256, 128), levels[0][0][0])
+
+	// Too many chunks can't hold their proofs
+	opts = DefaultOptions()
+	opts.Columns, opts.Rows = 100, 50
+	_, err = CalculateLevelBounds(img, opts)
+	assert.Error(t, err)
+
+	opts.Columns, opts.Rows = 301, 1
+	_, err = CalculateLevelBounds(img, opts)
+	assert.Error(t, err)
+
+	opts = DefaultOptions()
+	opts.ChunkWidth, opts.ChunkHeight = 4, 4
+	_, err = CalculateLevelBounds(img, opts)
+	assert.Error(t, err)
+}
+
+func TestSolveLayout(t *testing.T) {
+	for _, size := range []image.Point{{1038, 435}, {435, 1038}, {3000, 100}, {640, 480}, {100, 100}} {
+		for _, hashBits := range []int{256, 64} {
+			cols, rows := solveLayout(size.X, size.Y, 3, hashBits, 1)
+			require.True(t, layoutFits(size.X, size.Y, cols, rows, 3, hashBits, 1), size)
+
+			// The chunks are close to square
+			assert.LessOrEqual(t, chunkSkew(size.X, size.Y, cols, rows), math.Log(maxChunkAspect), size)
+		}
+	}
+
+	// The panorama of the car example gets more chunks than with the first release and they are not strips
+	cols, rows := solveLayout(1038, 435, 3, 256, 1)
+	legacyCols, legacyRows := legacyLayout(1038, 435)
+	assert.Greater(t, cols*rows, legacyCols*legacyRows)
+	assert.Greater(t, cols, rows)
+
+	// A single chunk always fits
+	cols, rows = solveLayout(4, 4, 3, 256, 1)
+	assert.Equal(t, 1, cols)
+	assert.Equal(t, 1, rows)
+}
+
+func TestSolveLayout_Narrow(t *testing.T) {
+	// Wide and tall images get a single row or column and are treated alike
+	cols, rows := solveLayout(2000, 3, 3, 256, 1)
+	assert.Equal(t, 1, rows)
+	assert.Greater(t, cols, 1)
+	tallCols, tallRows := solveLayout(3, 2000, 3, 256, 1)
+	assert.Equal(t, cols, tallRows)
+	assert.Equal(t, rows, tallCols)
+	assert.True(t, layoutFits(3, 2000, tallCols, tallRows, 3, 256, 1))
+}
+
+func TestSolveLayout_NoSquareGrid(t *testing.T) {
+	// Two square chunks of 16x16 or 20x20 pixels don't fit, but two halves do
+	for _, side := range []int{16, 20} {
+		cols, rows := solveLayout(side, side, 3, 256, 1)
+		assert.Equal(t, 2, cols*rows, side)
+		assert.True(t, layoutFits(side, side, cols, rows, 3, 256, 1), side)
+		assert.False(t, layoutFits(side, side, 2, 2, 3, 256, 1), side)
+	}
+}
+
+func TestCalculateChunkBounds(t *testing.T) {
+	img := blackImage(1038, 435)
+
+	// Only images of the first release keep their layout, options without any fields set fall back to the
+	// defaults and are laid out by the solver
+	bounds := v0Bounds(img)
+	assert.Len(t, bounds, 32)
+	assert.Len(t, bounds[0], 16)
+
+	bounds = CalculateChunkBounds(img, Options{})
+	cols, rows := solveLayout(1038, 435, 3, 256, 1)
+	assert.Len(t, bounds, cols)
+	assert.Len(t, bounds[0], rows)
+	assert.Equal(t, bounds, CalculateChunkBounds(img, DefaultOptions()))
+}
+
+func TestCalculateLevelBounds_Tiny(t *testing.T) {
+	opts := DefaultOptions()
+
+	// Two chunks of 5x10 pixels can't hold their proofs, but a single one holds the root
+	levels, err := CalculateLevelBounds(blackImage(10, 10), opts)
+	require.NoError(t, err)
+	require.Len(t, levels, 1)
+	assert.Equal(t, [][]image.Rectangle{{image.Rect(0, 0, 10, 10)}}, levels[0])
+
+	// 8x8 pixels have 192 payload bits, the 256 bits of the root and its 32 bit check value need 96 pixels
+	_, err = CalculateLevelBounds(blackImage(8, 8), opts)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "10x10 pixels")
+
+	_, err = CalculateLevelBounds(blackImage(0, 0), opts)
+	assert.Error(t, err)
+
+	// Two levels of a single chunk are two leaves with a proof of one hash each
+	opts.Levels = 2
+	levels, err = CalculateLevelBounds(blackImage(10, 10), opts)
+	require.NoError(t, err)
+	require.Len(t, levels, 2)
+	assert.Equal(t, levels[1], levels[0])
+}
+
+func TestLegacyLayout(t *testing.T) {
+	// The grid of the car example as the first release laid it out
+	cols, rows := legacyLayout(1038, 435)
+	assert.Equal(t, 32, cols)
+	assert.Equal(t, 16, rows)
+
+	// Images that are too small for two chunks don't hang
+	cols, rows = legacyLayout(10, 10)
+	assert.Equal(t, 1, cols)
+	assert.Equal(t, 1, rows)
+}
@@ -1,21 +1,28 @@
 package chunk
 
 import (
+	"crypto/ed25519"
 	"encoding/hex"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"log"
 	"path"
 
-	"github.com/cbergoon/merkletree"
+	"dennis-tra/image-stego/pkg/jpegdct"
+	"dennis-tra/image-stego/pkg/merkle"
 )
 
-func Encode(filepath string, outdir string) error {
+func Encode(filepath string, outdir string, opts Options) error {
 	filename := path.Base(filepath)
 
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
 	log.Println("Opening image:", filepath)
-	originalImg, err := OpenImageFile(filepath)
+	originalImg, format, err := OpenImageFile(filepath)
 	if err != nil {
 		return err
 	}
@@ -23,26 +30,88 @@ func Encode(filepath string, outdir string) error {
 	// copy original image for the checker pattern image to visualize the chunk bounds
 	checkerImg := ImageToRGBA(originalImg.SubImage(originalImg.Bounds()))
 
-	list := []merkletree.Content{}
+	// copy original image that will carry the encoded data. The chunks are sub images of it and
+	// share its pixels, so writing to a chunk writes to the encoded image.
+	var encodedImg Image
+	if opts.DCT {
+		log.Println("Transforming image into quantized DCT coefficients with quality", opts.Quality)
+		encodedImg = jpegdct.FromImage(originalImg, opts.Quality)
+	} else {
+		encodedImg = ToImage(originalImg)
+	}
+
+	// Indexed images carry the payload in their palette indices which requires groups of similar colors
+	if paletted, ok := encodedImg.(*image.Paletted); ok {
+		log.Println("Pairing palette colors...")
+		PairPalette(paletted, opts.Depth*opts.levels())
+	}
+
+	list := []*Chunk{}
 
+	log.Println("Options:", opts)
 	log.Println("Calculating bounds...")
-	bounds := CalculateChunkBounds(originalImg)
+	levels, err := CalculateLevelBounds(encodedImg, opts)
+	if err != nil {
+		return err
+	}
+
+	// The finest level is the grid of chunks that just fit their proofs unless it is fixed
+	bounds := levels[len(levels)-1]
+
+	if opts.fixedLayout() {
+		log.Printf("Fixed layout: %dx%d chunks", len(bounds), len(bounds[0]))
+	} else if opts.ProofHashBits > 0 {
+		full := opts
+		full.ProofHashBits = 0
+		fullBounds := CalculateChunkBounds(encodedImg, full)
+		log.Printf("Proof hashes are truncated to %d bits: %d chunks per level instead of %d with full %d bit hashes, "+
+			"but forging a chunk only takes about 2^%d instead of 2^%d hash evaluations",
+			opts.ProofHashBits, len(bounds)*len(bounds[0]), len(fullBounds)*len(fullBounds[0]), opts.Hash.BitLength(),
+			opts.ProofHashBits, opts.Hash.BitLength())
+	}
+
+	// The position of each chunk is bound into its hash to detect moved chunks
+	imageSize := pixelRect(encodedImg, encodedImg.Bounds()).Size()
 
 	log.Println("Building merkle tree...")
-	for _, boundsRow := range bounds {
-		for _, bound := range boundsRow {
-			list = append(list, &Chunk{
-				RGBA: ImageToRGBA(originalImg.SubImage(bound)),
-			})
+	for level, levelBounds := range levels {
+		if len(levels) > 1 {
+			log.Printf("Level %d: %dx%d chunks\n", level, len(levelBounds), len(levelBounds[0]))
+		}
+		for _, boundsRow := range levelBounds {
+			for _, bound := range boundsRow {
+				list = append(list, &Chunk{
+					Image:     encodedImg.SubImage(bound).(Image),
+					Channels:  opts.Channels,
+					Depth:     opts.Depth,
+					Planes:    len(levels),
+					Plane:     len(levels) - 1 - level,
+					Hash:      opts.Hash,
+					MACKey:    opts.MACKey,
+					Index:     len(list),
+					ImageSize: imageSize,
+					Key:       opts.Key,
+				})
+			}
 		}
 	}
 
-	// Create a new Merkle Tree from the list of Content
-	tree, err := merkletree.NewTree(list)
+	// Create a new Merkle Tree from the chunk hashes as leaves
+	leaves := make([][]byte, len(list))
+	for i, chunk := range list {
+		if leaves[i], err = chunk.CalculateHash(); err != nil {
+			return err
+		}
+	}
+
+	tree, err := merkle.New(opts.merkleHasher(), leaves)
 	if err != nil {
 		return err
 	}
-	log.Println("Merkle Tree Root Hash:", hex.EncodeToString(tree.MerkleRoot()))
+	log.Println("Merkle Tree Root Hash:", hex.EncodeToString(tree.Root()))
+	if len(list) == 1 {
+		log.Println("The image is too small for more than one chunk, the root is stored in the chunk instead of a proof")
+	}
 
 	log.Println("Drawing checker pattern overlay image...")
 	for x, boundRow := range bounds {
@@ -57,7 +126,7 @@ func Encode(filepath string, outdir string) error {
 
 			draw.DrawMask(
 				checkerImg,
-				bound,
+				pixelRect(encodedImg, bound),
 				&image.Uniform{C: clr},
 				image.Point{},
 				&image.Uniform{C: color.RGBA{R: 255, G: 255, B: 255, A: 80}},
@@ -75,37 +144,85 @@ func Encode(filepath string, outdir string) error {
 	}
 
 	log.Println("Encoding Merkle Tree information into LSBs of the image")
-	encodedImg := image.NewRGBA(originalImg.Bounds())
-	for x, boundsRow := range bounds {
-		for y, bound := range boundsRow {
+	pathCountBits := uint8(PathCountBitLength(len(list)))
+	for _, chunk := range list {
+
+		// The proof hashes are already truncated to the proof hash length
+		proof, err := tree.Proof(chunk.Index)
+		if err != nil {
+			return err
+		}
 
-			chunk := list[x*len(boundsRow)+y].(*Chunk)
+		sides, err := merkle.ProofSides(chunk.Index, len(list))
+		if err != nil {
+			return err
+		}
 
-			paths, sides, err := tree.GetMerklePath(chunk)
-			if err != nil {
+		err = chunk.WriteBits(uint64(len(proof)), pathCountBits)
+		if err != nil {
+			return err
+		}
+
+		for i, hash := range proof {
+			if err = chunk.WriteBool(sides[i]); err != nil {
 				return err
 			}
 
-			buf := []byte{}
-			buf = append(buf, uint8(len(paths)))
-			for i, path := range paths {
-				side := uint8(sides[i])
-				buf = append(buf, side)
-				buf = append(buf, path...)
+			if _, err = chunk.Write(hash); err != nil {
+				return err
 			}
+		}
 
-			_, err = chunk.Write(buf)
-			if err != nil {
+		// A single chunk has no proof, so the root is stored to tell whether its content has been modified.
+		// Its check value tells whether the root itself has been damaged.
+		if len(list) == 1 {
+			if _, err = chunk.Write(tree.Root()); err != nil {
+				return err
+			}
+			if _, err = chunk.Write(rootCheck(tree.Root())); err != nil {
 				return err
 			}
+		}
+
+		if err = writeSubBlockHashes(chunk, len(list), opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.SubBlocks > 1 {
+		grids := map[int]int{}
+		for _, chunk := range list {
+			grids[subBlockGrid(chunk, len(list), opts)]++
+		}
+		for grid := opts.SubBlocks; grid > 1; grid-- {
+			if grids[grid] > 0 {
+				log.Printf("Embedded the hashes of %dx%d sub-blocks into %d chunks\n", grid, grid, grids[grid])
+			}
+		}
+		if grids[0] > 0 {
+			log.Printf("%d chunks have no capacity left for sub-block hashes\n", grids[0])
+		}
+	}
 
-			draw.Draw(encodedImg, bound, chunk, image.Point{}, draw.Src)
+	if opts.SigningKey != nil {
+		copies, err := writeSignatureBlock(list, signRoot(opts.SigningKey, tree.Root()), opts)
+		if err != nil {
+			return fmt.Errorf("signing the Merkle root: %w", err)
 		}
+		log.Printf("Embedded the signature of the Merkle Root with key %s %d times", hex.EncodeToString(KeyID(opts.SigningKey.Public().(ed25519.PublicKey))), copies)
+	}
+
+	// GIFs stay GIFs, DCT coefficients are saved as a JPEG and everything else as a PNG
+	ext := ".png"
+	if opts.DCT {
+		ext = ".jpg"
+	} else if format == "gif" {
+		ext = ".gif"
 	}
 
-	encodedFilepath := path.Join(outdir, SetExtension(filename, ".png"))
+	encodedFilepath := path.Join(outdir, SetExtension(filename, ext))
 	log.Println("Saving encoded image:", encodedFilepath)
-	err = SaveImageFile(encodedFilepath, encodedImg)
+	err = SaveEncodedImageFile(encodedFilepath, encodedImg, opts)
 	if err != nil {
 		return err
 	}
@@ -1,33 +1,135 @@
 package chunk
 
 import (
+	"bytes"
+	"errors"
 	"image"
 	"image/draw"
+	"image/gif"
+	_ "image/gif"
 	_ "image/jpeg"
 	"image/png"
 	_ "image/png"
+	"io/ioutil"
 	"os"
 	"path"
+	"strings"
+
+	"dennis-tra/image-stego/pkg/jpegdct"
 )
 
-// OpenImageFile opens the file at the given path and returns the decoded *image.RGBA
-func OpenImageFile(filename string) (*image.RGBA, error) {
+// OpenImageFile opens the file at the given path and returns the decoded Image (see ToImage) and the
+// name of its format like "png", "jpeg" or "gif". Only the first frame of an animated GIF is considered.
+func OpenImageFile(filename string) (Image, string, error) {
 	file, err := os.Open(filename)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	img, _, err := image.Decode(file)
+	img, format, err := image.Decode(file)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	err = file.Close()
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	return ToImage(img), format, nil
+}
+
+// OpenEncodedImageFile opens the encoded image at the given path and returns the decoded Image
+// together with the options that were used to encode it. If the file does not carry any options
+// the default options are returned, such an image is verified in the format of the first release
+// (see v0.go). Images that were encoded in the DCT domain are returned as *jpegdct.Image with their
+// quantized DCT coefficients.
+func OpenEncodedImageFile(filename string) (Image, Options, error) {
+	img, opts, _, err := openEncodedImageFile(filename)
+	return img, opts, err
+}
+
+// openEncodedImageFile is OpenEncodedImageFile but additionally returns whether the file carries options.
+func openEncodedImageFile(filename string) (Image, Options, bool, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, Options{}, false, err
+	}
+
+	opts := DefaultOptions()
+	payload, found := findOptions(data)
+	if found {
+		if err = opts.UnmarshalBinary(payload); err != nil {
+			return nil, Options{}, false, err
+		}
+	}
+
+	if opts.DCT {
+		coeffs, err := jpegdct.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, Options{}, false, err
+		}
+		return coeffs, opts, found, nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, Options{}, false, err
+	}
+
+	return ToImage(img), opts, found, nil
+}
+
+// SaveEncodedImageFile saves the given encoded image to the given filepath and records the given options
+// alongside the pixel data. If the filepath has a .gif extension the image is saved as a GIF, which requires
+// an indexed image, and the options are stored in an application extension. If it has a .jpg or .jpeg extension
+// the image is saved as a JPEG, which requires a DCT coefficient image, and the options are stored in an
+// application segment. Otherwise, it is saved as a PNG
+// image and the options are stored in a private ancillary chunk. 16-bit images are saved as 16-bit PNGs
+// and indexed images as indexed PNGs.
+func SaveEncodedImageFile(filepath string, img Image, opts Options) error {
+	payload, err := opts.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	var data []byte
+	ext := strings.ToLower(path.Ext(filepath))
+	if ext == ".jpg" || ext == ".jpeg" {
+		coeffs, ok := img.(*jpegdct.Image)
+		if !ok {
+			return errors.New("only DCT coefficient images can be saved as JPEG")
+		}
+
+		if err = jpegdct.Encode(&buf, coeffs); err != nil {
+			return err
+		}
+
+		data, err = insertJPEGSegment(buf.Bytes(), optionsJPEGMarker, optionsAppID, payload)
+	} else if ext == ".gif" {
+		paletted, ok := img.(*image.Paletted)
+		if !ok {
+			return errors.New("only indexed images can be saved as GIF")
+		}
+
+		if err = gif.Encode(&buf, paletted, &gif.Options{NumColors: len(paletted.Palette)}); err != nil {
+			return err
+		}
+
+		data, err = insertGIFExtension(buf.Bytes(), optionsAppID, payload)
+	} else {
+		if err = png.Encode(&buf, img); err != nil {
+			return err
+		}
+
+		data, err = insertPNGChunk(buf.Bytes(), optionsChunkType, payload)
+	}
+	if err != nil {
+		return err
 	}
 
-	return ImageToRGBA(img), nil
+	return ioutil.WriteFile(filepath, data, 0644)
 }
 
 // SaveImageFile saves the given image data to the given filepath as a PNG image.
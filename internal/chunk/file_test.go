@@ -0,0 +1,177 @@
+package chunk
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"dennis-tra/image-stego/pkg/jpegdct"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveOpenEncodedImageFile_PreservesStraightAlpha(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	copy(img.Pix, []byte{
+		200, 100, 51, 0, // fully transparent pixel with color information
+		201, 99, 50, 1,
+		17, 255, 3, 128,
+	})
+
+	opts := DefaultOptions()
+	opts.Channels = ChannelsRGBA
+	opts.Depth = 2
+
+	filepath := path.Join(dir, "encoded.png")
+	require.NoError(t, SaveEncodedImageFile(filepath, img, opts))
+
+	parsed, parsedOpts, err := OpenEncodedImageFile(filepath)
+	require.NoError(t, err)
+
+	assert.Equal(t, opts, parsedOpts)
+	require.IsType(t, &image.NRGBA{}, parsed)
+	assert.Equal(t, img.Pix, parsed.(*image.NRGBA).Pix)
+}
+
+func TestSaveOpenEncodedImageFile_Preserves16Bit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	img := image.NewNRGBA64(image.Rect(0, 0, 2, 1))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i*37 + 1)
+	}
+
+	filepath := path.Join(dir, "encoded.png")
+	require.NoError(t, SaveEncodedImageFile(filepath, img, DefaultOptions()))
+
+	parsed, _, err := OpenEncodedImageFile(filepath)
+	require.NoError(t, err)
+
+	require.IsType(t, &image.NRGBA64{}, parsed)
+	assert.Equal(t, img.Pix, parsed.(*image.NRGBA64).Pix)
+}
+
+func TestOpenEncodedImageFile_DefaultOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	filepath := path.Join(dir, "plain.png")
+	require.NoError(t, SaveImageFile(filepath, image.NewNRGBA(image.Rect(0, 0, 1038, 435))))
+
+	img, opts, err := OpenEncodedImageFile(filepath)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultOptions(), opts)
+	assert.Equal(t, 1038, img.Bounds().Dx())
+}
+
+func TestSaveOpenEncodedImageFile_PreservesGray(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	img := image.NewGray(image.Rect(0, 0, 3, 2))
+	copy(img.Pix, []byte{0, 1, 2, 127, 128, 255})
+
+	filepath := path.Join(dir, "encoded.png")
+	require.NoError(t, SaveEncodedImageFile(filepath, img, DefaultOptions()))
+
+	parsed, _, err := OpenEncodedImageFile(filepath)
+	require.NoError(t, err)
+
+	require.IsType(t, &image.Gray{}, parsed)
+	assert.Equal(t, img.Pix, parsed.(*image.Gray).Pix)
+}
+
+func TestSaveOpenEncodedImageFile_GIF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	img := image.NewPaletted(image.Rect(0, 0, 3, 1), color.Palette{
+		color.RGBA{R: 255, A: 255},
+		color.RGBA{G: 255, A: 255},
+		color.RGBA{B: 255, A: 255},
+		color.RGBA{R: 255, G: 255, B: 255, A: 255},
+	})
+	copy(img.Pix, []byte{3, 1, 2})
+
+	opts := DefaultOptions()
+	opts.Depth = 2
+
+	filepath := path.Join(dir, "encoded.gif")
+	require.NoError(t, SaveEncodedImageFile(filepath, img, opts))
+
+	parsed, parsedOpts, err := OpenEncodedImageFile(filepath)
+	require.NoError(t, err)
+	assert.Equal(t, opts, parsedOpts)
+
+	require.IsType(t, &image.Paletted{}, parsed)
+	assert.Equal(t, img.Pix, parsed.(*image.Paletted).Pix)
+	assert.Equal(t, sharedContentOf(img), sharedContentOf(parsed))
+}
+
+func TestSaveEncodedImageFile_GIFRequiresPalette(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = SaveEncodedImageFile(path.Join(dir, "encoded.gif"), image.NewNRGBA(image.Rect(0, 0, 1, 1)), DefaultOptions())
+	assert.Error(t, err)
+}
+
+func TestSaveOpenEncodedImageFile_JPEG(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	src := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+
+	opts := DefaultOptions()
+	opts.DCT = true
+	img := jpegdct.FromImage(src, opts.Quality)
+	chunk := &Chunk{Image: img}
+	_, err = chunk.Write([]byte{0xde, 0xad, 0xbe, 0xef})
+	require.NoError(t, err)
+
+	filepath := path.Join(dir, "encoded.jpg")
+	require.NoError(t, SaveEncodedImageFile(filepath, img, opts))
+
+	parsed, parsedOpts, err := OpenEncodedImageFile(filepath)
+	require.NoError(t, err)
+	assert.Equal(t, opts, parsedOpts)
+
+	require.IsType(t, &jpegdct.Image{}, parsed)
+	assert.Equal(t, img.Pix, parsed.(*jpegdct.Image).Pix)
+	assert.Equal(t, sharedContentOf(img), sharedContentOf(parsed))
+
+	// The file is a regular JPEG image
+	file, err := os.Open(filepath)
+	require.NoError(t, err)
+	defer file.Close()
+	_, format, err := image.Decode(file)
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+}
+
+func TestSaveEncodedImageFile_JPEGRequiresCoefficients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = SaveEncodedImageFile(path.Join(dir, "encoded.jpg"), image.NewNRGBA(image.Rect(0, 0, 1, 1)), DefaultOptions())
+	assert.Error(t, err)
+}
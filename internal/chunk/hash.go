@@ -0,0 +1,162 @@
+package chunk
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/sha3"
+)
+
+// HashAlgorithm identifies the hash function that is used for the Merkle tree leaves (the chunk hashes)
+// and nodes. The identifier is recorded in the encoded image, so it must never change once assigned.
+type HashAlgorithm uint8
+
+// The built-in hash algorithms.
+const (
+	SHA256 HashAlgorithm = iota + 1
+	SHA512_256
+	SHA512
+	SHA3_256
+	SHA3_512
+	BLAKE2b256
+	BLAKE2b512
+	BLAKE2s256
+)
+
+// hashFunc is a registered hash algorithm.
+type hashFunc struct {
+	name string
+	new  func() hash.Hash
+	size int
+}
+
+// hashRegistry holds all known hash algorithms by their identifier.
+var hashRegistry = map[HashAlgorithm]hashFunc{}
+
+func init() {
+	RegisterHash(SHA256, "sha256", sha256.New)
+	RegisterHash(SHA512_256, "sha512/256", sha512.New512_256)
+	RegisterHash(SHA512, "sha512", sha512.New)
+	RegisterHash(SHA3_256, "sha3-256", sha3.New256)
+	RegisterHash(SHA3_512, "sha3-512", sha3.New512)
+	RegisterHash(BLAKE2b256, "blake2b-256", mustHash(blake2b.New256))
+	RegisterHash(BLAKE2b512, "blake2b-512", mustHash(blake2b.New512))
+	RegisterHash(BLAKE2s256, "blake2s-256", mustHash(blake2s.New256))
+}
+
+// RegisterHash registers a hash function under the given identifier and name, so it can be selected for
+// encoding and is found when decoding an image that records the identifier. It panics if the identifier
+// or the name is already taken.
+func RegisterHash(id HashAlgorithm, name string, new func() hash.Hash) {
+	if id == 0 {
+		panic("hash algorithm identifier 0 is reserved")
+	}
+	if _, exists := hashRegistry[id]; exists {
+		panic(fmt.Sprintf("hash algorithm %d already registered", id))
+	}
+	if _, err := ParseHashAlgorithm(name); err == nil {
+		panic(fmt.Sprintf("hash algorithm %q already registered", name))
+	}
+	hashRegistry[id] = hashFunc{name: name, new: new, size: new().Size()}
+}
+
+// mustHash adapts the constructor of a keyed hash function to an unkeyed one.
+func mustHash(new func(key []byte) (hash.Hash, error)) func() hash.Hash {
+	return func() hash.Hash {
+		h, err := new(nil)
+		if err != nil {
+			panic(err)
+		}
+		return h
+	}
+}
+
+// ParseHashAlgorithm returns the registered hash algorithm with the given (case insensitive) name.
+func ParseHashAlgorithm(name string) (HashAlgorithm, error) {
+	for id, f := range hashRegistry {
+		if strings.EqualFold(f.name, name) {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown hash algorithm %q, available: %s", name, strings.Join(HashAlgorithmNames(), ", "))
+}
+
+// HashAlgorithmNames returns the names of all registered hash algorithms sorted by their identifier.
+func HashAlgorithmNames() []string {
+	ids := make([]int, 0, len(hashRegistry))
+	for id := range hashRegistry {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = hashRegistry[HashAlgorithm(id)].name
+	}
+	return names
+}
+
+// Available returns true if the hash algorithm is registered.
+func (h HashAlgorithm) Available() bool {
+	_, ok := hashRegistry[h]
+	return ok
+}
+
+// New returns a new hash.Hash of the algorithm. It panics if the algorithm is not registered.
+func (h HashAlgorithm) New() hash.Hash {
+	return h.registered().new()
+}
+
+// Size returns the digest length of the algorithm in bytes. It panics if the algorithm is not registered.
+func (h HashAlgorithm) Size() int {
+	return h.registered().size
+}
+
+// BitLength returns the digest length of the algorithm in bits. It panics if the algorithm is not registered.
+func (h HashAlgorithm) BitLength() int {
+	return h.Size() * BitsPerByte
+}
+
+// String returns the name of the algorithm.
+func (h HashAlgorithm) String() string {
+	if f, ok := hashRegistry[h]; ok {
+		return f.name
+	}
+	return fmt.Sprintf("HashAlgorithm(%d)", uint8(h))
+}
+
+// newFunc returns the constructor of the hash function. If a key is given the constructor creates an
+// HMAC of the algorithm with that key instead.
+func (h HashAlgorithm) newFunc(key []byte) func() hash.Hash {
+	if len(key) == 0 {
+		return h.New
+	}
+	return func() hash.Hash {
+		return hmac.New(h.New, key)
+	}
+}
+
+func (h HashAlgorithm) registered() hashFunc {
+	f, ok := hashRegistry[h]
+	if !ok {
+		panic(fmt.Sprintf("unknown hash algorithm %d", uint8(h)))
+	}
+	return f
+}
+
+// rootCheck returns the check value of the root that a single chunk stores along with it. It tells a damaged
+// root apart from a modified content, but anyone can compute it.
+func rootCheck(root []byte) []byte {
+	check := make([]byte, RootCheckBitLength/BitsPerByte)
+	binary.BigEndian.PutUint32(check, crc32.ChecksumIEEE(root))
+	return check
+}
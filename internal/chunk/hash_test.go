@@ -0,0 +1,101 @@
+package chunk
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHashAlgorithm(t *testing.T) {
+	for _, name := range HashAlgorithmNames() {
+		h, err := ParseHashAlgorithm(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, h.String())
+		assert.Equal(t, h.Size(), len(h.New().Sum(nil)))
+	}
+
+	h, err := ParseHashAlgorithm("SHA3-256")
+	require.NoError(t, err)
+	assert.Equal(t, SHA3_256, h)
+	assert.Equal(t, 256, h.BitLength())
+	assert.Equal(t, 512, BLAKE2b512.BitLength())
+
+	_, err = ParseHashAlgorithm("md5")
+	assert.Error(t, err)
+}
+
+func TestRegisterHash_Duplicate(t *testing.T) {
+	assert.Panics(t, func() { RegisterHash(SHA256, "other", sha256.New) })
+	assert.Panics(t, func() { RegisterHash(HashAlgorithm(200), "sha256", sha256.New) })
+}
+
+func TestChunk_CalculateHashAlgorithm(t *testing.T) {
+	sha, err := (&Chunk{Image: blackImage(2, 2)}).CalculateHash()
+	require.NoError(t, err)
+	assert.Len(t, sha, 32)
+
+	explicit, err := (&Chunk{Image: blackImage(2, 2), Hash: SHA256}).CalculateHash()
+	require.NoError(t, err)
+	assert.Equal(t, sha, explicit)
+
+	blake, err := (&Chunk{Image: blackImage(2, 2), Hash: BLAKE2b256}).CalculateHash()
+	require.NoError(t, err)
+	assert.Len(t, blake, 32)
+	assert.NotEqual(t, sha, blake)
+
+	long, err := (&Chunk{Image: blackImage(2, 2), Hash: SHA512}).CalculateHash()
+	require.NoError(t, err)
+	assert.Len(t, long, 64)
+}
+
+func TestChunk_CalculateHashHMAC(t *testing.T) {
+	unkeyed, err := (&Chunk{Image: blackImage(1, 1)}).CalculateHash()
+	require.NoError(t, err)
+
+	keyed, err := (&Chunk{Image: blackImage(1, 1), MACKey: []byte("secret")}).CalculateHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, unkeyed, keyed)
+
+	// The leaf prefix and the four values of the single black pixel are authenticated
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte{0, 0, 0, 0, 0})
+	assert.Equal(t, mac.Sum(nil), keyed)
+
+	otherKey, err := (&Chunk{Image: blackImage(1, 1), MACKey: []byte("other")}).CalculateHash()
+	require.NoError(t, err)
+	assert.NotEqual(t, keyed, otherKey)
+
+	// The nodes of the tree are authenticated with the same key
+	opts := DefaultOptions()
+	opts.MACKey = []byte("secret")
+	mac = hmac.New(sha256.New, []byte("secret"))
+	mac.Write(append(append([]byte{1}, keyed...), keyed...))
+	assert.Equal(t, mac.Sum(nil), opts.merkleHasher().HashChildren(keyed, keyed))
+}
+
+func TestCalculateChunkBounds_DigestLength(t *testing.T) {
+	img := blackImage(400, 300)
+
+	opts := DefaultOptions()
+	short := CalculateChunkBounds(img, opts)
+
+	opts.Hash = SHA3_512
+	long := CalculateChunkBounds(img, opts)
+
+	assert.Greater(t, len(short)*len(short[0]), len(long)*len(long[0]))
+}
+
+func TestCalculateChunkBounds_TruncatedProofHashes(t *testing.T) {
+	img := blackImage(400, 300)
+
+	opts := DefaultOptions()
+	full := CalculateChunkBounds(img, opts)
+
+	opts.ProofHashBits = 64
+	truncated := CalculateChunkBounds(img, opts)
+
+	assert.Greater(t, len(truncated)*len(truncated[0]), len(full)*len(full[0]))
+}
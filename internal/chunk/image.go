@@ -0,0 +1,303 @@
+package chunk
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+
+	"dennis-tra/image-stego/pkg/jpegdct"
+)
+
+// Image is an image whose pixel values can carry payload bits. The supported image types are
+// *image.NRGBA for 8 bits per channel, *image.NRGBA64 for 16 bits per channel, *image.Gray and
+// *image.Gray16 for grayscale images and *image.Paletted for indexed images, whose palette index
+// carries the payload (see PairPalette). Use ToImage to convert an arbitrary image.Image to one of them.
+type Image interface {
+	image.Image
+	PixOffset(x, y int) int
+	SubImage(r image.Rectangle) image.Image
+}
+
+// pixelFormat describes how the values of a pixel are laid out in the Pix slice of an Image.
+type pixelFormat struct {
+	// The number of values (channels) per pixel.
+	channels int
+
+	// The number of bytes per value. Values with more than one byte are stored big endian,
+	// so the least significant bits of a value are found in its last byte.
+	bytesPerValue int
+
+	// payload holds the offsets of the values that carry payload if they are fixed by the format.
+	// If it is nil the selected channels carry the payload.
+	payload []int
+}
+
+// dctPayloadOffsets are the offsets of the coefficients of a DCT block that carry payload: the mid-frequency
+// AC coefficients of the luminance at the zig-zag positions 6 to 27. Changing them alters the image less
+// visibly than changing the low frequencies, while they are quantized less coarsely than the high frequencies.
+var dctPayloadOffsets = func() []int {
+	var offsets []int
+	for _, k := range jpegdct.Zigzag[6:28] {
+		offsets = append(offsets, k)
+	}
+	return offsets
+}()
+
+// pixelsOf returns the Pix slice and the pixel format of the given image.
+// It panics if the image is not one of the supported types (see Image).
+func pixelsOf(img Image) ([]uint8, pixelFormat) {
+	switch img := img.(type) {
+	case *image.NRGBA:
+		return img.Pix, pixelFormat{channels: 4, bytesPerValue: 1}
+	case *image.NRGBA64:
+		return img.Pix, pixelFormat{channels: 4, bytesPerValue: 2}
+	case *image.Gray:
+		return img.Pix, pixelFormat{channels: 1, bytesPerValue: 1, payload: []int{0}}
+	case *image.Gray16:
+		return img.Pix, pixelFormat{channels: 1, bytesPerValue: 2, payload: []int{0}}
+	case *image.Paletted:
+		return img.Pix, pixelFormat{channels: 1, bytesPerValue: 1, payload: []int{0}}
+	case *jpegdct.Image:
+		return img.Pix, pixelFormat{channels: img.Components * jpegdct.BlockLen, bytesPerValue: 2, payload: dctPayloadOffsets}
+	default:
+		panic(fmt.Sprintf("unsupported image type %T", img))
+	}
+}
+
+// ToImage converts the given image to an Image that can carry payload bits while keeping its precision
+// and type. Grayscale and indexed images stay grayscale and indexed images, 16-bit color images are converted
+// to an *image.NRGBA64 and all other images to an *image.NRGBA. The returned image is always a copy and its
+// bounds start at (0, 0).
+func ToImage(src image.Image) Image {
+	bounds := image.Rect(0, 0, src.Bounds().Dx(), src.Bounds().Dy())
+
+	switch src := src.(type) {
+	case *image.Gray:
+		gray := image.NewGray(bounds)
+		copyImage(gray, src)
+		return gray
+	case *image.Gray16:
+		gray := image.NewGray16(bounds)
+		copyImage(gray, src)
+		return gray
+	case *image.Paletted:
+		paletted := image.NewPaletted(bounds, append(color.Palette{}, src.Palette...))
+		copyImage(paletted, src)
+		return paletted
+	case *image.NRGBA64, *image.RGBA64:
+		return ImageToNRGBA64(src)
+	default:
+		return ImageToNRGBA(src)
+	}
+}
+
+// ImageToNRGBA converts an image.Image to an *image.NRGBA with straight (non-premultiplied) alpha values.
+// The values of an *image.NRGBA (e.g. a PNG with an alpha channel) are copied as they are, because
+// drawing it would go through premultiplied colors and alter the color values of transparent pixels.
+func ImageToNRGBA(src image.Image) *image.NRGBA {
+	bounds := src.Bounds()
+	nrgba := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	if srcNRGBA, ok := src.(*image.NRGBA); ok {
+		copyImage(nrgba, srcNRGBA)
+		return nrgba
+	}
+
+	draw.Draw(nrgba, nrgba.Bounds(), src, bounds.Min, draw.Src)
+	return nrgba
+}
+
+// ImageToNRGBA64 converts an image.Image to an *image.NRGBA64 with straight (non-premultiplied) alpha values.
+// Like in ImageToNRGBA the values of an *image.NRGBA64 (e.g. a 16-bit PNG with an alpha channel) are
+// copied as they are.
+func ImageToNRGBA64(src image.Image) *image.NRGBA64 {
+	bounds := src.Bounds()
+	nrgba := image.NewNRGBA64(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	if srcNRGBA, ok := src.(*image.NRGBA64); ok {
+		copyImage(nrgba, srcNRGBA)
+		return nrgba
+	}
+
+	draw.Draw(nrgba, nrgba.Bounds(), src, bounds.Min, draw.Src)
+	return nrgba
+}
+
+// copyImage copies the pixel values of src verbatim to dst. Both images must be of the same type
+// and dimensions, while the bounds of dst must start at (0, 0).
+func copyImage(dst Image, src Image) {
+	dstPix, format := pixelsOf(dst)
+	srcPix, _ := pixelsOf(src)
+
+	bounds := src.Bounds()
+	rowLen := bounds.Dx() * format.channels * format.bytesPerValue
+	for y := 0; y < bounds.Dy(); y++ {
+		srcOff := src.PixOffset(bounds.Min.X, bounds.Min.Y+y)
+		dstOff := dst.PixOffset(0, y)
+		copy(dstPix[dstOff:dstOff+rowLen], srcPix[srcOff:srcOff+rowLen])
+	}
+}
+
+// maxPaletteLength is the maximum number of colors of a palette that every file format can store.
+const maxPaletteLength = 256
+
+// PairPalette prepares an indexed image for carrying payload in the given number of low bits of its palette
+// indices. The payload sets these bits to any value, so the palette is divided into groups of 2^bits entries
+// that only differ in them, and every color gets a group of colors that look alike. If the palette is small
+// enough, each color fills a group of its own, so the payload doesn't change any pixel. Otherwise the closest
+// colors share a group (see groupColors), so the payload changes a pixel to one of the colors nearest to it.
+// Fully transparent colors are normalized to transparent black as not all file formats can store their color.
+func PairPalette(img *image.Paletted, bits int) {
+	groupSize := 1 << uint(bits)
+
+	colors := make([]color.NRGBA, len(img.Palette))
+	for i, c := range img.Palette {
+		nrgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+		if nrgba.A == 0 {
+			nrgba = color.NRGBA{}
+		}
+		colors[i] = nrgba
+	}
+
+	groups := groupColors(colors, groupSize, maxPaletteLength/groupSize)
+
+	// Groups with fewer colors than entries repeat them
+	palette := make(color.Palette, 0, len(groups)*groupSize)
+	remap := make([]uint8, len(colors))
+	for _, group := range groups {
+		for i := 0; i < groupSize; i++ {
+			if i < len(group) {
+				remap[group[i]] = uint8(len(palette))
+			}
+			palette = append(palette, colors[group[i%len(group)]])
+		}
+	}
+
+	for i, idx := range img.Pix {
+		if int(idx) < len(remap) {
+			img.Pix[i] = remap[idx]
+		}
+	}
+	img.Palette = palette
+}
+
+// groupColors divides the indices of the given colors into at most maxGroups groups of at most groupSize
+// colors. Only if there are more than maxGroups colors, the two closest groups are merged until there are few
+// enough. The distance of two groups is the one of their most distant colors. The groups are merged in
+// rounds that double the size of the groups, so the colors can't end up in groups that are too full to be
+// merged. The groups and their colors are ordered by luminance.
+func groupColors(colors []color.NRGBA, groupSize, maxGroups int) [][]int {
+	groups := make([][]int, len(colors))
+	dist := make([][]int, len(colors))
+	for i := range colors {
+		groups[i] = []int{i}
+		dist[i] = make([]int, len(colors))
+		for j := range colors {
+			dist[i][j] = colorDistance(colors[i], colors[j])
+		}
+	}
+
+	count := len(groups)
+	for size := 2; size <= groupSize && count > maxGroups; size *= 2 {
+		for count > maxGroups {
+			a, b := -1, -1
+			for i := range groups {
+				for j := i + 1; j < len(groups); j++ {
+					if groups[i] == nil || groups[j] == nil || len(groups[i])+len(groups[j]) > size {
+						continue
+					}
+					if a < 0 || dist[i][j] < dist[a][b] {
+						a, b = i, j
+					}
+				}
+			}
+			if a < 0 {
+				break
+			}
+
+			groups[a], groups[b] = append(groups[a], groups[b]...), nil
+			for k := range groups {
+				if dist[b][k] > dist[a][k] {
+					dist[a][k], dist[k][a] = dist[b][k], dist[b][k]
+				}
+			}
+			count--
+		}
+	}
+
+	byLuminance := func(i, j int) bool {
+		li, lj := luminance(colors[i]), luminance(colors[j])
+		if li != lj {
+			return li < lj
+		}
+		return i < j
+	}
+
+	merged := make([][]int, 0, count)
+	for _, group := range groups {
+		if group != nil {
+			sort.Slice(group, func(i, j int) bool { return byLuminance(group[i], group[j]) })
+			merged = append(merged, group)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool { return byLuminance(merged[i][0], merged[j][0]) })
+
+	return merged
+}
+
+// colorDistance returns the squared euclidean distance of the given colors including their alpha values.
+func colorDistance(a, b color.NRGBA) int {
+	dr, dg := int(a.R)-int(b.R), int(a.G)-int(b.G)
+	db, da := int(a.B)-int(b.B), int(a.A)-int(b.A)
+	return dr*dr + dg*dg + db*db + da*da
+}
+
+// luminance returns the luma of the given color according to ITU-R BT.601 (like color.GrayModel).
+func luminance(c color.NRGBA) uint32 {
+	return (19595*uint32(c.R) + 38470*uint32(c.G) + 7471*uint32(c.B) + 1<<15) >> 16
+}
+
+// sharedContentOf returns the content that is shared by all chunks of the given image and determines
+// how their values look: the NRGBA values of the palette of an indexed image and the quantization tables
+// of a DCT coefficient image. It returns nil for all other images.
+func sharedContentOf(img Image) []byte {
+	switch img := img.(type) {
+	case *image.Paletted:
+		values := make([]byte, 0, 4*len(img.Palette))
+		for _, c := range img.Palette {
+			nrgba := color.NRGBAModel.Convert(c).(color.NRGBA)
+			values = append(values, nrgba.R, nrgba.G, nrgba.B, nrgba.A)
+		}
+		return values
+	case *jpegdct.Image:
+		values := make([]byte, 0, 2*jpegdct.BlockLen*len(img.Quant))
+		for _, table := range img.Quant {
+			for _, q := range table {
+				values = append(values, byte(q>>8), byte(q))
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// pixelImage returns the pixels of the given image. DCT coefficient images are decoded, all other
+// images are already made of pixels.
+func pixelImage(img Image) image.Image {
+	if coeffs, ok := img.(*jpegdct.Image); ok {
+		return coeffs.Image()
+	}
+	return img
+}
+
+// pixelRect returns the pixels that are covered by the given rectangle of the given image. Rectangles of
+// DCT coefficient images are measured in blocks, rectangles of all other images already in pixels.
+func pixelRect(img Image, r image.Rectangle) image.Rectangle {
+	if coeffs, ok := img.(*jpegdct.Image); ok {
+		return coeffs.PixelRect(r)
+	}
+	return r
+}
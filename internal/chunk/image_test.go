@@ -0,0 +1,83 @@
+package chunk
+
+import (
+	"image"
+	"image/color"
+	"image/color/palette"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToImage_Gray16SubImage(t *testing.T) {
+	img := image.NewGray16(image.Rect(0, 0, 2, 2))
+	copy(img.Pix, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+
+	converted := ToImage(img.SubImage(image.Rect(1, 0, 2, 2)))
+	require.IsType(t, &image.Gray16{}, converted)
+	assert.Equal(t, image.Rect(0, 0, 1, 2), converted.Bounds())
+	assert.Equal(t, []byte{3, 4, 7, 8}, converted.(*image.Gray16).Pix)
+}
+
+func TestImageToNRGBA_SubImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	copy(img.Pix, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 0})
+
+	converted := ImageToNRGBA(img.SubImage(image.Rect(1, 0, 2, 2)))
+	assert.Equal(t, image.Rect(0, 0, 1, 2), converted.Bounds())
+	assert.Equal(t, []byte{5, 6, 7, 8, 13, 14, 15, 0}, converted.Pix)
+}
+
+func TestPairPalette(t *testing.T) {
+	white := color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	black := color.NRGBA{A: 255}
+	gray := color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+
+	img := image.NewPaletted(image.Rect(0, 0, 3, 1), color.Palette{white, black, gray})
+	copy(img.Pix, []byte{0, 1, 2})
+
+	PairPalette(img, 1)
+
+	// A small palette repeats every color, ordered by luminance, so the payload bit doesn't change any pixel
+	assert.Equal(t, color.Palette{black, black, gray, gray, white, white}, img.Palette)
+	assert.Equal(t, []byte{4, 0, 2}, img.Pix)
+	for _, idx := range img.Pix {
+		assert.Equal(t, img.Palette[idx], img.Palette[idx^1])
+	}
+}
+
+func TestPairPalette_Full(t *testing.T) {
+	// The 256 colors of the Plan 9 palette don't fit twice, so the closest colors are paired
+	img := image.NewPaletted(image.Rect(0, 0, 256, 1), append(color.Palette{}, palette.Plan9...))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+
+	PairPalette(img, 1)
+
+	require.Len(t, img.Palette, 256)
+	for i, idx := range img.Pix {
+		// Pixels still show the same colors
+		assert.Equal(t, color.NRGBAModel.Convert(palette.Plan9[i]), img.Palette[idx])
+
+		// The other color of the pair differs by less than 25% in every channel
+		a, b := img.Palette[idx].(color.NRGBA), img.Palette[idx^1].(color.NRGBA)
+		for _, d := range []int{int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B)} {
+			assert.Less(t, d*d, 64*64, i)
+		}
+	}
+}
+
+func TestPairPalette_Depth(t *testing.T) {
+	img := image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.NRGBA{R: 10, A: 0}, color.Black})
+
+	PairPalette(img, 3)
+
+	// Every color fills a group of 8 entries
+	require.Len(t, img.Palette, 16)
+
+	// Fully transparent colors are normalized
+	assert.Equal(t, color.NRGBA{}, img.Palette[0])
+	assert.Equal(t, color.NRGBA{}, img.Palette[7])
+}
@@ -0,0 +1,259 @@
+package chunk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// This file contains the helpers to store the encoding options alongside the pixel data in
+// the containers of the supported file formats.
+
+// optionsChunkType is the type of the private ancillary PNG chunk that holds the encoding options.
+// Lower case first letter: ancillary, lower case second letter: private, lower case last letter: safe to copy.
+const optionsChunkType = "stEg"
+
+// pngHeader is the signature every PNG file starts with.
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+// optionsAppID is the application identifier (8 bytes) and authentication code (3 bytes) of the
+// GIF application extension that holds the encoding options.
+const optionsAppID = "IMGSTEGO1.0"
+
+// optionsJPEGMarker is the marker of the JPEG application segment (APP11) that holds the encoding options.
+// Its data starts with optionsAppID to tell it apart from segments of other applications.
+const optionsJPEGMarker = 0xeb
+
+// findOptions returns the encoding options stored in the given PNG, GIF or JPEG data. The second return
+// value is false if no options could be found.
+func findOptions(data []byte) ([]byte, bool) {
+	if payload, found := findPNGChunk(data, optionsChunkType); found {
+		return payload, true
+	}
+	if payload, found := findJPEGSegment(data, optionsJPEGMarker, optionsAppID); found {
+		return payload, true
+	}
+	return findGIFExtension(data, optionsAppID)
+}
+
+// insertPNGChunk inserts a chunk of the given type and payload right after the IHDR chunk of the given PNG data.
+func insertPNGChunk(data []byte, typ string, payload []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(pngHeader)) || len(data) < len(pngHeader)+8 {
+		return nil, errors.New("invalid png data")
+	}
+
+	// The IHDR chunk is always the first one: 4 bytes length, 4 bytes type, data, 4 bytes CRC
+	ihdrEnd := len(pngHeader) + 12 + int(binary.BigEndian.Uint32(data[len(pngHeader):]))
+
+	chunk := make([]byte, 8, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk[:4], uint32(len(payload)))
+	copy(chunk[4:8], typ)
+	chunk = append(chunk, payload...)
+	chunk = append(chunk, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(chunk[8+len(payload):], crc32.ChecksumIEEE(chunk[4:8+len(payload)]))
+
+	result := make([]byte, 0, len(data)+len(chunk))
+	result = append(result, data[:ihdrEnd]...)
+	result = append(result, chunk...)
+	result = append(result, data[ihdrEnd:]...)
+
+	return result, nil
+}
+
+// findPNGChunk returns the payload of the first chunk of the given type in the given PNG data. The second return
+// value is false if the data is not a PNG file, is malformed or does not contain a chunk of the given type.
+func findPNGChunk(data []byte, typ string) ([]byte, bool) {
+	if !bytes.HasPrefix(data, []byte(pngHeader)) {
+		return nil, false
+	}
+
+	for off := len(pngHeader); off+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[off:]))
+		end := off + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, false
+		}
+
+		chunk := data[off+4 : end-4]
+		if string(chunk[:4]) == typ {
+			if crc32.ChecksumIEEE(chunk) != binary.BigEndian.Uint32(data[end-4:]) {
+				return nil, false
+			}
+			return chunk[4:], true
+		}
+
+		off = end
+	}
+
+	return nil, false
+}
+
+// gifScreenEnd returns the offset of the first block after the header, the logical screen descriptor
+// and the global color table of the given GIF data.
+func gifScreenEnd(data []byte) (int, error) {
+	if len(data) < 13 || !(bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a"))) {
+		return 0, errors.New("invalid gif data")
+	}
+
+	off := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		off += 3 * (1 << (uint(flags&0x07) + 1))
+	}
+
+	return off, nil
+}
+
+// skipGIFSubBlocks returns the offset after the sequence of data sub-blocks starting at off.
+func skipGIFSubBlocks(data []byte, off int) int {
+	for off < len(data) && data[off] != 0 {
+		off += 1 + int(data[off])
+	}
+	return off + 1
+}
+
+// insertGIFExtension inserts an application extension with the given identifier and payload
+// right after the global color table of the given GIF data.
+func insertGIFExtension(data []byte, id string, payload []byte) ([]byte, error) {
+	off, err := gifScreenEnd(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(id) != 11 {
+		return nil, errors.New("gif application identifier must be 11 bytes long")
+	}
+
+	ext := []byte{0x21, 0xff, 11}
+	ext = append(ext, id...)
+	for len(payload) > 0 {
+		n := len(payload)
+		if n > 255 {
+			n = 255
+		}
+		ext = append(ext, byte(n))
+		ext = append(ext, payload[:n]...)
+		payload = payload[n:]
+	}
+	ext = append(ext, 0)
+
+	result := make([]byte, 0, len(data)+len(ext))
+	result = append(result, data[:off]...)
+	result = append(result, ext...)
+	result = append(result, data[off:]...)
+
+	// Application extensions were introduced with version 89a
+	copy(result, "GIF89a")
+
+	return result, nil
+}
+
+// findGIFExtension returns the payload of the first application extension with the given identifier in
+// the given GIF data. The second return value is false if the data is not a GIF file, is malformed or does
+// not contain such an extension.
+func findGIFExtension(data []byte, id string) ([]byte, bool) {
+	off, err := gifScreenEnd(data)
+	if err != nil {
+		return nil, false
+	}
+
+	for off < len(data) {
+		switch data[off] {
+		case 0x21: // extension
+			if off+2 >= len(data) {
+				return nil, false
+			}
+			label := data[off+1]
+			start := off + 2
+			off = skipGIFSubBlocks(data, start)
+			if off > len(data) {
+				return nil, false
+			}
+
+			if label != 0xff || data[start] != 11 || start+12 > len(data) || string(data[start+1:start+12]) != id {
+				continue
+			}
+
+			var payload []byte
+			for i := start + 12; i < off-1; i += 1 + int(data[i]) {
+				payload = append(payload, data[i+1:i+1+int(data[i])]...)
+			}
+			return payload, true
+		case 0x2c: // image descriptor
+			if off+10 > len(data) {
+				return nil, false
+			}
+			flags := data[off+9]
+			off += 10
+			if flags&0x80 != 0 {
+				off += 3 * (1 << (uint(flags&0x07) + 1))
+			}
+			// skip LZW minimum code size and image data
+			off = skipGIFSubBlocks(data, off+1)
+		default: // trailer or garbage
+			return nil, false
+		}
+	}
+
+	return nil, false
+}
+
+// insertJPEGSegment inserts an application segment with the given marker, identifier and payload into the
+// given JPEG data. It is inserted right after the start of image marker or, if present, after the JFIF
+// APP0 segment which has to come first.
+func insertJPEGSegment(data []byte, marker byte, id string, payload []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("invalid jpeg data")
+	}
+
+	off := 2
+	if data[2] == 0xff && data[3] == 0xe0 && len(data) >= 6 {
+		off += 2 + int(binary.BigEndian.Uint16(data[4:]))
+	}
+
+	length := 2 + len(id) + len(payload)
+	if length > 0xffff || off > len(data) {
+		return nil, errors.New("invalid jpeg segment")
+	}
+
+	segment := []byte{0xff, marker, byte(length >> 8), byte(length)}
+	segment = append(segment, id...)
+	segment = append(segment, payload...)
+
+	result := make([]byte, 0, len(data)+len(segment))
+	result = append(result, data[:off]...)
+	result = append(result, segment...)
+	result = append(result, data[off:]...)
+
+	return result, nil
+}
+
+// findJPEGSegment returns the payload of the first segment with the given marker whose data starts with
+// the given identifier. Only the segments in front of the first scan are searched. The second return
+// value is false if the data is not a JPEG file, is malformed or does not contain such a segment.
+func findJPEGSegment(data []byte, marker byte, id string) ([]byte, bool) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, false
+	}
+
+	for off := 2; off+4 <= len(data) && data[off] == 0xff; {
+		m := data[off+1]
+		if m == 0xda || m == 0xd9 { // start of scan or end of image
+			return nil, false
+		}
+
+		end := off + 2 + int(binary.BigEndian.Uint16(data[off+2:]))
+		if end > len(data) {
+			return nil, false
+		}
+
+		segment := data[off+4 : end]
+		if m == marker && bytes.HasPrefix(segment, []byte(id)) {
+			return segment[len(id):], true
+		}
+
+		off = end
+	}
+
+	return nil, false
+}
@@ -0,0 +1,494 @@
+package chunk
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"dennis-tra/image-stego/pkg/merkle"
+)
+
+// Channel is a bit mask of the color channels of a pixel whose least significant bits carry payload.
+type Channel uint8
+
+const (
+	ChannelR Channel = 1 << iota
+	ChannelG
+	ChannelB
+	ChannelA
+
+	// ChannelsRGB selects the three color channels. This is the default.
+	ChannelsRGB = ChannelR | ChannelG | ChannelB
+
+	// ChannelsRGBA selects all channels.
+	ChannelsRGBA = ChannelsRGB | ChannelA
+)
+
+// channelOrder is the order in which the channels of a pixel are filled with payload bits.
+// It matches the order of the values in the Pix slice of an image.
+var channelOrder = []Channel{ChannelR, ChannelG, ChannelB, ChannelA}
+
+// ParseChannels parses a string like "rgb", "b" or "rgba" into a Channel mask.
+func ParseChannels(s string) (Channel, error) {
+	var c Channel
+	for _, r := range strings.ToLower(s) {
+		var ch Channel
+		switch r {
+		case 'r':
+			ch = ChannelR
+		case 'g':
+			ch = ChannelG
+		case 'b':
+			ch = ChannelB
+		case 'a':
+			ch = ChannelA
+		default:
+			return 0, fmt.Errorf("invalid channel %q in %q", r, s)
+		}
+		if c.Has(ch) {
+			return 0, fmt.Errorf("duplicate channel %q in %q", r, s)
+		}
+		c |= ch
+	}
+
+	if c == 0 {
+		return 0, errors.New("no channels selected")
+	}
+
+	return c, nil
+}
+
+// Has returns true if all channels of o are part of c.
+func (c Channel) Has(o Channel) bool {
+	return c&o == o
+}
+
+// Count returns the number of selected channels, which is the number of payload bits per pixel.
+func (c Channel) Count() int {
+	n := 0
+	for _, ch := range channelOrder {
+		if c.Has(ch) {
+			n++
+		}
+	}
+	return n
+}
+
+// Offsets returns the offsets of the selected channels within the four values of a pixel in the Pix slice.
+func (c Channel) Offsets() []int {
+	var offsets []int
+	for i, ch := range channelOrder {
+		if c.Has(ch) {
+			offsets = append(offsets, i)
+		}
+	}
+	return offsets
+}
+
+// String returns the lower case letters of the selected channels, e.g. "rgb".
+func (c Channel) String() string {
+	s := ""
+	for i, ch := range channelOrder {
+		if c.Has(ch) {
+			s += string("rgba"[i])
+		}
+	}
+	return s
+}
+
+// Options configure how the Merkle tree information is embedded into the image. The options used
+// during encoding are recorded in the encoded image so that decoding uses the same layout.
+type Options struct {
+	// Channels selects the color channels whose least significant bits carry the payload.
+	Channels Channel
+
+	// Depth is the number of low bits (1-4) of each payload channel that carry the payload, at most two in
+	// DCT mode. The more bits are used the finer the chunk grid gets but the more the image is altered.
+	Depth int
+
+	// Key is a secret key that pseudo-randomly spreads the payload bits over the LSBs of each chunk.
+	// Without the key an attacker can neither locate nor rewrite the embedded Merkle paths.
+	// The key itself is never recorded in the image, only the fact that one was used.
+	Key []byte
+
+	// DCT embeds the payload into the quantized DCT coefficients of a JPEG image instead of the LSBs of
+	// the pixels. The image is saved as a JPEG whose coefficients are written as they are, so the encoded
+	// image can be distributed as a JPEG. The embedded data doesn't survive a recompression though, which
+	// requantizes the coefficients, nor the removal of the segment that records the options. Channels is
+	// ignored in this mode.
+	DCT bool
+
+	// Quality is the JPEG quality (1-100) that determines the quantization of the DCT coefficients.
+	// It is only used for encoding in DCT mode, the quantization tables are part of the JPEG image.
+	Quality int
+
+	// Hash is the hash algorithm of the Merkle tree leaves and nodes. Longer digests need more
+	// payload bits per chunk, so the chunks get larger.
+	Hash HashAlgorithm
+
+	// ProofHashBits truncates the hashes of the Merkle proof that are stored in each chunk to the given
+	// number of bits (a multiple of 8) while the root keeps the full digest length. Shorter hashes allow
+	// more and smaller chunks, but forging a chunk only takes about 2^ProofHashBits hash evaluations.
+	// Zero stores the full hashes.
+	ProofHashBits int
+
+	// MACKey is a shared secret that turns the hashes of the chunks and of the Merkle tree nodes into HMACs.
+	// Only holders of the secret can then produce chunks that lead to a valid root or verify them, so an
+	// edited image can't be encoded again by anyone else. Like Key it is never recorded in the image, only
+	// the fact that one was used.
+	MACKey []byte
+
+	// SigningKey signs the Merkle root during encoding. The key ID and the signature are embedded repeatedly
+	// in the spare capacity of the chunks, so anyone who trusts the public key can tell that the signer
+	// vouches for the root. Like Key it is never recorded in the image, only the fact that one was used.
+	SigningKey ed25519.PrivateKey
+
+	// SubBlocks divides each chunk into up to SubBlocks x SubBlocks sub-blocks whose truncated hashes are
+	// embedded in the capacity the chunk has left after its Merkle proof. They localise the changes within a
+	// chunk whose content has been modified. Chunks with less capacity get fewer sub-blocks, zero disables them.
+	// The sub-block hashes are only as trustworthy as the MAC key (see MACKey), without one they are a hint.
+	SubBlocks int
+
+	// Levels lays the chunks out as a quadtree of the given number of levels instead of a single grid. The
+	// finest level is the grid of chunks that just fit their proofs, every coarser level merges 2x2 chunks
+	// of the next finer one. All chunks are leaves of the same Merkle tree, but every level carries its
+	// payload in its own group of Depth low bits, the finest one in the least significant bits. So tampering
+	// is reported at coarse and fine granularity and still localised if the payload of the fine chunks is
+	// damaged. Zero or one lays out a single grid.
+	Levels int
+
+	// Columns and Rows fix the grid of the finest level of chunks instead of searching the finest grid whose
+	// chunks just fit their proofs. Coarser grids have larger chunks with more spare capacity, e.g. for
+	// sub-block hashes or signatures. Zero searches the grid automatically.
+	Columns, Rows int
+
+	// ChunkWidth and ChunkHeight fix the size of the chunks of the finest level in pixels. The chunks at the
+	// right and bottom edges are smaller if the size doesn't divide the image. In DCT mode the size must be a
+	// multiple of the 8x8 pixel blocks. Zero searches the grid automatically. It can't be combined with Columns.
+	ChunkWidth, ChunkHeight int
+
+	// keyed is true if the image was encoded with a key. It is set when options are read from an image.
+	keyed bool
+
+	// authenticated is true if the image was encoded with a MAC key. It is set when options are read from an image.
+	authenticated bool
+
+	// signed is true if the image was encoded with a signing key. It is set when options are read from an image.
+	signed bool
+}
+
+// DecodeOptions configure the verification of an encoded image. Unlike Options they are not recorded
+// in the image as they contain secrets.
+type DecodeOptions struct {
+	// Key is the secret key the image was encoded with (see Options.Key).
+	Key []byte
+
+	// MACKey is the shared secret the image was encoded with (see Options.MACKey).
+	MACKey []byte
+
+	// Root is the trusted Merkle root the chunks are verified against. Without it the root that most chunks
+	// lead to is assumed to be the original one, which an attacker who rewrites more than half of the chunks
+	// or re-encodes the whole image controls.
+	Root []byte
+
+	// TrustedKeys are the public keys of the signers whose signatures of the Merkle root are trusted
+	// (see Options.SigningKey).
+	TrustedKeys []TrustedKey
+
+	// Logger receives the progress of the verification. Without it the progress isn't logged, the outcome
+	// is only returned as a Report (see Report.Log).
+	Logger *log.Logger
+}
+
+// logger returns the Logger or a logger that discards the progress if there is none.
+func (o DecodeOptions) logger() *log.Logger {
+	if o.Logger == nil {
+		return log.New(ioutil.Discard, "", 0)
+	}
+	return o.Logger
+}
+
+// ParseSize parses a size like "8x4" into its two positive components, e.g. the columns and rows of a grid
+// of chunks (see Options.Columns) or the width and height of a chunk (see Options.ChunkWidth).
+func ParseSize(s string) (int, int, error) {
+	parts := strings.Split(strings.ToLower(s), "x")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid size %q, must be like 8x4", s)
+	}
+
+	x, err := strconv.Atoi(parts[0])
+	if err != nil || x < 1 {
+		return 0, 0, fmt.Errorf("invalid size %q, must be like 8x4", s)
+	}
+
+	y, err := strconv.Atoi(parts[1])
+	if err != nil || y < 1 {
+		return 0, 0, fmt.Errorf("invalid size %q, must be like 8x4", s)
+	}
+
+	return x, y, nil
+}
+
+// ParseRoot parses a hex encoded Merkle root. Only the first whitespace separated field is considered, so
+// the root can be followed by a file name or a comment.
+func ParseRoot(s string) ([]byte, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, errors.New("empty merkle root")
+	}
+
+	root, err := hex.DecodeString(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid merkle root %q: %w", fields[0], err)
+	}
+
+	return root, nil
+}
+
+// ReadRootFile reads a hex encoded Merkle root from the given file (see ParseRoot).
+func ReadRootFile(filename string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRoot(string(data))
+}
+
+// Keyed returns true if the payload is placed with a secret key.
+func (o Options) Keyed() bool {
+	return o.keyed || len(o.Key) > 0
+}
+
+// Authenticated returns true if the hashes of the chunks and the Merkle tree nodes are HMACs.
+func (o Options) Authenticated() bool {
+	return o.authenticated || len(o.MACKey) > 0
+}
+
+// Signed returns true if the Merkle root is signed.
+func (o Options) Signed() bool {
+	return o.signed || o.SigningKey != nil
+}
+
+// MaxDepth is the maximum number of low bits per channel that can carry payload.
+const MaxDepth = 4
+
+// MaxDCTDepth is the maximum number of low bits of each DCT coefficient that can carry payload, the levels
+// included (see Options.DCT). The coefficients are quantized so coarsely that every further bit alters the
+// image considerably.
+const MaxDCTDepth = 2
+
+// MaxLevels is the maximum number of levels of the quadtree layout (see Options.Levels).
+const MaxLevels = MaxDepth
+
+// MinProofHashBits is the minimum length in bits the proof hashes can be truncated to.
+const MinProofHashBits = 32
+
+// ProofHashBitLength returns the number of bits of each hash of the Merkle proof that is stored in a chunk.
+func (o Options) ProofHashBitLength() int {
+	if o.ProofHashBits > 0 {
+		return o.ProofHashBits
+	}
+	return o.Hash.BitLength()
+}
+
+// String returns a short description of the options for the log, e.g. "channels rgb, depth 1, hash sha256,
+// keyed". The keys are never part of it.
+func (o Options) String() string {
+	parts := []string{"channels " + o.Channels.String()}
+	if o.DCT {
+		parts[0] = "dct"
+	}
+	parts = append(parts, fmt.Sprintf("depth %d", o.Depth), "hash "+o.Hash.String())
+
+	if o.ProofHashBits > 0 {
+		parts = append(parts, fmt.Sprintf("%d bit proof hashes", o.ProofHashBits))
+	}
+	if o.Keyed() {
+		parts = append(parts, "keyed")
+	}
+	if o.Authenticated() {
+		parts = append(parts, "hmac")
+	}
+	if o.Signed() {
+		parts = append(parts, "signed")
+	}
+	if o.SubBlocks > 1 {
+		parts = append(parts, fmt.Sprintf("%dx%d sub-blocks", o.SubBlocks, o.SubBlocks))
+	}
+	if o.levels() > 1 {
+		parts = append(parts, fmt.Sprintf("%d levels", o.levels()))
+	}
+	if o.Columns > 0 {
+		parts = append(parts, fmt.Sprintf("grid %dx%d", o.Columns, o.Rows))
+	}
+	if o.ChunkWidth > 0 {
+		parts = append(parts, fmt.Sprintf("chunk size %dx%d", o.ChunkWidth, o.ChunkHeight))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// levels returns the number of levels of chunks, which is one for a single grid.
+func (o Options) levels() int {
+	if o.Levels < 1 {
+		return 1
+	}
+	return o.Levels
+}
+
+// fixedLayout returns true if the grid of chunks is fixed by Columns and Rows or by ChunkWidth and ChunkHeight
+// instead of searched automatically.
+func (o Options) fixedLayout() bool {
+	return o.Columns > 0 || o.ChunkWidth > 0
+}
+
+// merkleHasher returns the hasher of the Merkle tree nodes, which truncates the children of a node to the
+// length of the proof hashes and computes HMACs if a MAC key is set.
+func (o Options) merkleHasher() merkle.Hasher {
+	return merkle.Hasher{New: o.Hash.newFunc(o.MACKey), Truncate: o.ProofHashBitLength() / BitsPerByte}
+}
+
+// DefaultOptions returns the options that are used if nothing else is specified or if an image
+// does not carry any options.
+func DefaultOptions() Options {
+	return Options{
+		Channels: ChannelsRGB,
+		Depth:    1,
+		Quality:  90,
+		Hash:     SHA256,
+	}
+}
+
+// Validate checks the options for consistency.
+func (o Options) Validate() error {
+	if o.Channels == 0 || o.Channels&^ChannelsRGBA != 0 {
+		return fmt.Errorf("invalid channel selection %08b", o.Channels)
+	}
+	if o.Depth < 1 || o.Depth > MaxDepth {
+		return fmt.Errorf("invalid embedding depth %d, must be between 1 and %d", o.Depth, MaxDepth)
+	}
+	if !o.Hash.Available() {
+		return fmt.Errorf("unknown hash algorithm %d", uint8(o.Hash))
+	}
+	if o.ProofHashBits != 0 && (o.ProofHashBits%BitsPerByte != 0 || o.ProofHashBits < MinProofHashBits || o.ProofHashBits > o.Hash.BitLength()) {
+		return fmt.Errorf("invalid proof hash length %d, must be a multiple of 8 between %d and %d", o.ProofHashBits, MinProofHashBits, o.Hash.BitLength())
+	}
+	if o.SigningKey != nil && len(o.SigningKey) != ed25519.PrivateKeySize {
+		return fmt.Errorf("invalid signing key length %d", len(o.SigningKey))
+	}
+	if o.Levels < 0 || o.Levels > MaxLevels || o.levels()*o.Depth > MaxDepth {
+		return fmt.Errorf("invalid number of levels %d, the levels times the depth must not exceed %d", o.Levels, MaxDepth)
+	}
+	if o.SubBlocks < 0 || o.SubBlocks > MaxSubBlocks {
+		return fmt.Errorf("invalid number of sub-blocks %d, must be between 0 and %d", o.SubBlocks, MaxSubBlocks)
+	}
+	if o.Columns < 0 || o.Rows < 0 || o.Columns > math.MaxUint16 || o.Rows > math.MaxUint16 || (o.Columns == 0) != (o.Rows == 0) {
+		return fmt.Errorf("invalid grid of %dx%d chunks", o.Columns, o.Rows)
+	}
+	if o.ChunkWidth < 0 || o.ChunkHeight < 0 || o.ChunkWidth > math.MaxUint16 || o.ChunkHeight > math.MaxUint16 || (o.ChunkWidth == 0) != (o.ChunkHeight == 0) {
+		return fmt.Errorf("invalid chunk size %dx%d", o.ChunkWidth, o.ChunkHeight)
+	}
+	if o.Columns > 0 && o.ChunkWidth > 0 {
+		return errors.New("a grid of chunks and a chunk size can't be combined")
+	}
+	if o.DCT && o.levels()*o.Depth > MaxDCTDepth {
+		return fmt.Errorf("invalid embedding depth %d with %d levels, the levels times the depth must not exceed %d in dct mode", o.Depth, o.levels(), MaxDCTDepth)
+	}
+	if o.DCT && (o.Quality < 1 || o.Quality > 100) {
+		return fmt.Errorf("invalid jpeg quality %d, must be between 1 and 100", o.Quality)
+	}
+	return nil
+}
+
+// optionsVersion is the version of the binary options format.
+const optionsVersion = 1
+
+// optionsLength is the length of the binary options, optionsLayoutLength the length of the fixed layout that
+// is appended if there is one.
+const (
+	optionsLength       = 8
+	optionsLayoutLength = 8
+)
+
+// Flags of the binary options format.
+const (
+	flagKeyed byte = 1 << iota
+	flagDCT
+	flagSigned
+	flagAuthenticated
+)
+
+// MarshalBinary encodes the options into a compact binary form that is stored in the encoded image.
+// The keys are never encoded.
+func (o Options) MarshalBinary() ([]byte, error) {
+	var flags byte
+	if o.Keyed() {
+		flags |= flagKeyed
+	}
+	if o.DCT {
+		flags |= flagDCT
+	}
+	if o.Signed() {
+		flags |= flagSigned
+	}
+	if o.Authenticated() {
+		flags |= flagAuthenticated
+	}
+	data := []byte{optionsVersion, byte(o.Channels), byte(o.Depth), flags, byte(o.Hash), byte(o.ProofHashBits / BitsPerByte), byte(o.SubBlocks), byte(o.Levels)}
+
+	// The fixed layout is only appended if there is one
+	if o.fixedLayout() {
+		layout := make([]byte, optionsLayoutLength)
+		binary.BigEndian.PutUint16(layout[0:], uint16(o.Columns))
+		binary.BigEndian.PutUint16(layout[2:], uint16(o.Rows))
+		binary.BigEndian.PutUint16(layout[4:], uint16(o.ChunkWidth))
+		binary.BigEndian.PutUint16(layout[6:], uint16(o.ChunkHeight))
+		data = append(data, layout...)
+	}
+
+	return data, nil
+}
+
+// UnmarshalBinary decodes options that were encoded with MarshalBinary.
+func (o *Options) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("options data too short")
+	}
+
+	if data[0] != optionsVersion {
+		return fmt.Errorf("unsupported options version %d", data[0])
+	}
+
+	if len(data) != optionsLength && len(data) != optionsLength+optionsLayoutLength {
+		return fmt.Errorf("options data has %d bytes instead of %d or %d", len(data), optionsLength, optionsLength+optionsLayoutLength)
+	}
+
+	*o = DefaultOptions()
+	o.Channels = Channel(data[1])
+	o.Depth = int(data[2])
+	o.keyed = data[3]&flagKeyed != 0
+	o.DCT = data[3]&flagDCT != 0
+	o.signed = data[3]&flagSigned != 0
+	o.authenticated = data[3]&flagAuthenticated != 0
+	o.Hash = HashAlgorithm(data[4])
+	o.ProofHashBits = int(data[5]) * BitsPerByte
+	o.SubBlocks = int(data[6])
+	o.Levels = int(data[7])
+
+	if len(data) > optionsLength {
+		layout := data[optionsLength:]
+		o.Columns = int(binary.BigEndian.Uint16(layout[0:]))
+		o.Rows = int(binary.BigEndian.Uint16(layout[2:]))
+		o.ChunkWidth = int(binary.BigEndian.Uint16(layout[4:]))
+		o.ChunkHeight = int(binary.BigEndian.Uint16(layout[6:]))
+	}
+
+	return o.Validate()
+}
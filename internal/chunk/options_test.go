@@ -0,0 +1,236 @@
+package chunk
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseChannels(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Channel
+		wantErr bool
+	}{
+		{in: "rgb", want: ChannelsRGB},
+		{in: "RGBA", want: ChannelsRGBA},
+		{in: "b", want: ChannelB},
+		{in: "gr", want: ChannelR | ChannelG},
+		{in: "", wantErr: true},
+		{in: "rr", wantErr: true},
+		{in: "x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseChannels(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestChannel_Offsets(t *testing.T) {
+	assert.Equal(t, []int{0, 1, 2}, ChannelsRGB.Offsets())
+	assert.Equal(t, []int{2, 3}, (ChannelB | ChannelA).Offsets())
+	assert.Equal(t, "ba", (ChannelB | ChannelA).String())
+	assert.Equal(t, 2, (ChannelB | ChannelA).Count())
+}
+
+func TestOptions_MarshalUnmarshal(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Channels = ChannelG | ChannelA
+	opts.Depth = 3
+
+	data, err := opts.MarshalBinary()
+	require.NoError(t, err)
+
+	var parsed Options
+	require.NoError(t, parsed.UnmarshalBinary(data))
+	assert.Equal(t, opts, parsed)
+
+	// The key is never encoded, only the fact that one was used
+	opts.Key = []byte("secret")
+	data, err = opts.MarshalBinary()
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+
+	require.NoError(t, parsed.UnmarshalBinary(data))
+	assert.True(t, parsed.Keyed())
+	assert.Nil(t, parsed.Key)
+
+	// The hash algorithm is recorded
+	opts = DefaultOptions()
+	opts.Hash = BLAKE2b512
+	data, err = opts.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, parsed.UnmarshalBinary(data))
+	assert.Equal(t, opts, parsed)
+	assert.Error(t, parsed.UnmarshalBinary([]byte{optionsVersion, byte(ChannelsRGB), 1, 0, 0xff}))
+
+	// So is the length of the truncated proof hashes
+	opts = DefaultOptions()
+	opts.ProofHashBits = 64
+	data, err = opts.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, parsed.UnmarshalBinary(data))
+	assert.Equal(t, opts, parsed)
+	assert.Equal(t, 64, parsed.ProofHashBitLength())
+	assert.Equal(t, 256, DefaultOptions().ProofHashBitLength())
+
+	// The use of a MAC key is recorded, the key is not
+	opts = DefaultOptions()
+	opts.MACKey = []byte("secret")
+	data, err = opts.MarshalBinary()
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	require.NoError(t, parsed.UnmarshalBinary(data))
+	assert.True(t, parsed.Authenticated())
+	assert.Nil(t, parsed.MACKey)
+	assert.False(t, DefaultOptions().Authenticated())
+
+	// A signature is recorded, the signing key is not
+	opts = DefaultOptions()
+	opts.SigningKey = ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	data, err = opts.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, parsed.UnmarshalBinary(data))
+	assert.True(t, parsed.Signed())
+	assert.Nil(t, parsed.SigningKey)
+
+	for _, bits := range []int{12, 16, 264} {
+		opts.ProofHashBits = bits
+		assert.Error(t, opts.Validate(), bits)
+	}
+
+	// So is the number of sub-blocks
+	opts = DefaultOptions()
+	opts.SubBlocks = 4
+	data, err = opts.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, parsed.UnmarshalBinary(data))
+	assert.Equal(t, opts, parsed)
+	opts.SubBlocks = MaxSubBlocks + 1
+	assert.Error(t, opts.Validate())
+
+	// So is the number of levels, which is limited by the depth
+	opts = DefaultOptions()
+	opts.Levels = 2
+	opts.Depth = 2
+	data, err = opts.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, parsed.UnmarshalBinary(data))
+	assert.Equal(t, opts, parsed)
+	opts.Depth = 3
+	assert.Error(t, opts.Validate())
+
+	// So is a fixed grid or chunk size, but not both
+	opts = DefaultOptions()
+	opts.Columns, opts.Rows = 300, 2
+	data, err = opts.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, parsed.UnmarshalBinary(data))
+	assert.Equal(t, opts, parsed)
+	opts = DefaultOptions()
+	opts.ChunkWidth, opts.ChunkHeight = 64, 48
+	data, err = opts.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, parsed.UnmarshalBinary(data))
+	assert.Equal(t, opts, parsed)
+	opts.Columns, opts.Rows = 8, 4
+	assert.Error(t, opts.Validate())
+	opts = DefaultOptions()
+	opts.Columns = 8
+	assert.Error(t, opts.Validate())
+
+	// The DCT mode is recorded but not the quality
+	opts = DefaultOptions()
+	opts.DCT = true
+	opts.Quality = 50
+	data, err = opts.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, parsed.UnmarshalBinary(data))
+	assert.True(t, parsed.DCT)
+	assert.Equal(t, DefaultOptions().Quality, parsed.Quality)
+
+	// The DCT coefficients carry at most two bits
+	opts.Depth = MaxDCTDepth
+	assert.NoError(t, opts.Validate())
+	opts.Depth = MaxDCTDepth + 1
+	assert.Error(t, opts.Validate())
+	opts.Depth, opts.Levels = 1, MaxDCTDepth+1
+	assert.Error(t, opts.Validate())
+
+	// Only complete records are accepted
+	data, err = DefaultOptions().MarshalBinary()
+	require.NoError(t, err)
+	require.Len(t, data, optionsLength)
+	for i := 0; i < len(data); i++ {
+		assert.Error(t, parsed.UnmarshalBinary(data[:i]), i)
+	}
+	assert.Error(t, parsed.UnmarshalBinary(append(data, 0)))
+	assert.Error(t, parsed.UnmarshalBinary(append(data, make([]byte, optionsLayoutLength-1)...)))
+
+	invalid := append([]byte{}, data...)
+	invalid[2] = MaxDepth + 1
+	assert.Error(t, parsed.UnmarshalBinary(invalid))
+	invalid = append([]byte{}, data...)
+	invalid[1] = 0
+	assert.Error(t, parsed.UnmarshalBinary(invalid))
+}
+
+func TestOptions_String(t *testing.T) {
+	opts := DefaultOptions()
+	assert.Equal(t, "channels rgb, depth 1, hash sha256", opts.String())
+
+	opts.DCT = true
+	opts.Key = []byte("key")
+	opts.MACKey = []byte("mac")
+	opts.ProofHashBits = 64
+	opts.SubBlocks = 4
+	opts.Levels = 2
+	opts.Columns, opts.Rows = 8, 4
+	assert.Equal(t, "dct, depth 1, hash sha256, 64 bit proof hashes, keyed, hmac, 4x4 sub-blocks, 2 levels, grid 8x4", opts.String())
+}
+
+func TestParseSize(t *testing.T) {
+	x, y, err := ParseSize("8x4")
+	require.NoError(t, err)
+	assert.Equal(t, 8, x)
+	assert.Equal(t, 4, y)
+
+	x, y, err = ParseSize("64X48")
+	require.NoError(t, err)
+	assert.Equal(t, 64, x)
+	assert.Equal(t, 48, y)
+
+	for _, s := range []string{"", "8", "8x", "x4", "0x4", "8x-1", "8x4x2", "axb"} {
+		_, _, err = ParseSize(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestParseRoot(t *testing.T) {
+	root, err := ParseRoot("778e09a6\n")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x77, 0x8e, 0x09, 0xa6}, root)
+
+	// Anything after the root is ignored
+	root, err = ParseRoot("  778E09A6  car.png\n")
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0x77, 0x8e, 0x09, 0xa6}, root)
+
+	_, err = ParseRoot(" \n")
+	assert.Error(t, err)
+
+	_, err = ParseRoot("778e09a")
+	assert.Error(t, err)
+
+	_, err = ParseRoot("not-hex")
+	assert.Error(t, err)
+}
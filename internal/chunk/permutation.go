@@ -0,0 +1,96 @@
+package chunk
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// permutation is a keyed pseudo-random permutation of the numbers 0 to n-1. It is used to spread the
+// payload bits of a chunk over its least significant bits, so that without the key their positions
+// are unknown. Only the positions that are actually requested are computed (partial Fisher-Yates shuffle),
+// because the payload usually occupies a small fraction of the available bits.
+type permutation struct {
+	n      int
+	stream *keyStream
+
+	// The already computed first positions of the permutation.
+	prefix []int
+
+	// The values of the conceptual array [0, n) that were swapped by the shuffle.
+	swapped map[int]int
+}
+
+// newPermutation returns the permutation of n positions for the chunk with the given index.
+func newPermutation(key []byte, chunkIndex int, n int) *permutation {
+	return &permutation{
+		n:       n,
+		stream:  newKeyStream(key, chunkIndex),
+		swapped: map[int]int{},
+	}
+}
+
+// At returns the i-th position of the permutation.
+func (p *permutation) At(i int) int {
+	for j := len(p.prefix); j <= i; j++ {
+		r := j + p.stream.Intn(p.n-j)
+		vj, vr := p.value(j), p.value(r)
+		p.swapped[r] = vj
+		p.prefix = append(p.prefix, vr)
+	}
+	return p.prefix[i]
+}
+
+// value returns the current value at index i of the conceptual array that is shuffled.
+func (p *permutation) value(i int) int {
+	if v, ok := p.swapped[i]; ok {
+		return v
+	}
+	return i
+}
+
+// keyStream is a deterministic stream of pseudo-random numbers derived from a secret key and a chunk index.
+// The numbers are generated by HMAC-SHA256 of the chunk index and an incrementing counter.
+type keyStream struct {
+	key        []byte
+	chunkIndex uint64
+	counter    uint64
+	buf        []byte
+}
+
+func newKeyStream(key []byte, chunkIndex int) *keyStream {
+	return &keyStream{key: key, chunkIndex: uint64(chunkIndex)}
+}
+
+// Uint64 returns the next 64 pseudo-random bits of the stream.
+func (s *keyStream) Uint64() uint64 {
+	if len(s.buf) < 8 {
+		msg := make([]byte, 16)
+		binary.BigEndian.PutUint64(msg[:8], s.chunkIndex)
+		binary.BigEndian.PutUint64(msg[8:], s.counter)
+		s.counter++
+
+		mac := hmac.New(sha256.New, s.key)
+		mac.Write(msg)
+		s.buf = mac.Sum(nil)
+	}
+
+	v := binary.BigEndian.Uint64(s.buf)
+	s.buf = s.buf[8:]
+	return v
+}
+
+// Intn returns a uniformly distributed pseudo-random number in [0, n). It panics if n <= 0.
+func (s *keyStream) Intn(n int) int {
+	if n <= 0 {
+		panic("invalid argument to Intn")
+	}
+
+	// Reject values of the last incomplete range to avoid a modulo bias
+	max := ^uint64(0) - ^uint64(0)%uint64(n)
+	for {
+		if v := s.Uint64(); v < max {
+			return int(v % uint64(n))
+		}
+	}
+}
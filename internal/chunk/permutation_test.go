@@ -0,0 +1,46 @@
+package chunk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermutation_IsPermutation(t *testing.T) {
+	n := 100
+	p := newPermutation([]byte("secret"), 3, n)
+
+	seen := map[int]bool{}
+	for i := 0; i < n; i++ {
+		v := p.At(i)
+		assert.GreaterOrEqual(t, v, 0)
+		assert.Less(t, v, n)
+		assert.False(t, seen[v], "position %d returned twice", v)
+		seen[v] = true
+	}
+}
+
+func TestPermutation_Deterministic(t *testing.T) {
+	p1 := newPermutation([]byte("secret"), 3, 1000)
+	p2 := newPermutation([]byte("secret"), 3, 1000)
+
+	// Requesting a later position first must not change the result
+	assert.Equal(t, p1.At(20), p2.At(20))
+	for i := 0; i < 50; i++ {
+		assert.Equal(t, p1.At(i), p2.At(i))
+	}
+}
+
+func TestPermutation_DependsOnKeyAndIndex(t *testing.T) {
+	positions := func(key string, index int) []int {
+		p := newPermutation([]byte(key), index, 1<<20)
+		var result []int
+		for i := 0; i < 16; i++ {
+			result = append(result, p.At(i))
+		}
+		return result
+	}
+
+	assert.NotEqual(t, positions("secret", 0), positions("secret", 1))
+	assert.NotEqual(t, positions("secret", 0), positions("other", 0))
+}
@@ -0,0 +1,435 @@
+package chunk
+
+import (
+	"bytes"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"log"
+	"sort"
+)
+
+// Status is the outcome of the verification of an image.
+type Status int
+
+const (
+	// StatusClean means that all chunks lead to the Merkle root.
+	StatusClean Status = iota
+
+	// StatusTampered means that some chunks don't lead to the Merkle root.
+	StatusTampered
+
+	// StatusNoTrustedMatch means that no chunk leads to the trusted Merkle root (see DecodeOptions.Root).
+	// The image is either not the one the root belongs to or it has been tampered with as a whole.
+	StatusNoTrustedMatch
+
+	// StatusNotEncoded means that the image carries neither recorded options nor chunks that agree on a
+	// Merkle root, so it has most likely never been encoded.
+	StatusNotEncoded
+
+	// StatusUntrustedSignature means that all chunks lead to the Merkle root, but the root isn't signed by any
+	// of the trusted keys (see DecodeOptions.TrustedKeys). The image is unsigned, signed by another key or its
+	// signature is invalid, so it may have been encoded again after a manipulation.
+	StatusUntrustedSignature
+
+	// StatusNoCommonRoot means that the image carries options but no two of its chunks lead to the same Merkle
+	// root, so there is no root to judge the chunks against. Most likely the key or the HMAC key is wrong (see
+	// DecodeOptions), otherwise the payload of the whole image has been destroyed.
+	StatusNoCommonRoot
+)
+
+// String returns a short lower case description of the status.
+func (s Status) String() string {
+	switch s {
+	case StatusClean:
+		return "clean"
+	case StatusTampered:
+		return "tampered"
+	case StatusNoTrustedMatch:
+		return "no trusted match"
+	case StatusNotEncoded:
+		return "not encoded"
+	case StatusUntrustedSignature:
+		return "untrusted signature"
+	case StatusNoCommonRoot:
+		return "no common root"
+	default:
+		return "unknown"
+	}
+}
+
+// ChunkStatus is the outcome of the verification of a single chunk.
+type ChunkStatus int
+
+const (
+	// ChunkValid means that the chunk leads to the Merkle root.
+	ChunkValid ChunkStatus = iota
+
+	// ChunkRootMismatch means that the chunk has a well-formed proof that leads to another root. Usually
+	// the content of the chunk has been edited.
+	ChunkRootMismatch
+
+	// ChunkCorruptHeader means that the number of proof hashes embedded in the chunk doesn't match the
+	// position of the chunk in the tree, or that the root stored by a single chunk is damaged, so the chunk
+	// doesn't lead to any root.
+	ChunkCorruptHeader
+
+	// ChunkInvalidSide means that a side flag of the proof embedded in the chunk doesn't match the position
+	// of the chunk in the tree, so the chunk doesn't lead to any root.
+	ChunkInvalidSide
+
+	// ChunkTruncatedProof means that the chunk ends before the proof embedded in it does, so the chunk
+	// doesn't lead to any root.
+	ChunkTruncatedProof
+)
+
+// chunkStatuses are all chunk statuses in the order of their values.
+var chunkStatuses = []ChunkStatus{ChunkValid, ChunkRootMismatch, ChunkCorruptHeader, ChunkInvalidSide, ChunkTruncatedProof}
+
+// String returns a short lower case description of the chunk status.
+func (s ChunkStatus) String() string {
+	switch s {
+	case ChunkValid:
+		return "valid"
+	case ChunkRootMismatch:
+		return "root mismatch"
+	case ChunkCorruptHeader:
+		return "corrupt header"
+	case ChunkInvalidSide:
+		return "invalid side flag"
+	case ChunkTruncatedProof:
+		return "truncated proof"
+	default:
+		return "unknown"
+	}
+}
+
+// Tamper is the kind of manipulation of a chunk that doesn't lead to the root. It is derived from the hash
+// of the chunk, the embedded proof and the nodes of the tree of the root that are known from the chunks that
+// lead to it.
+type Tamper int
+
+const (
+	// TamperNone means that the chunk leads to the root.
+	TamperNone Tamper = iota
+
+	// TamperUnknown means that the chunk doesn't lead to the root but no chunk does or the image was encoded
+	// by the first release, so the manipulation can't be classified.
+	TamperUnknown
+
+	// TamperContent means that the chunk carries its own proof but its pixel content has been modified.
+	TamperContent
+
+	// TamperPayload means that the payload of the chunk has been destroyed, its proof can't be read for its
+	// position nor for any other.
+	TamperPayload
+
+	// TamperMoved means that the chunk has been moved (or copied) from another position of the image with its
+	// content and payload intact (see ChunkReport.MovedFrom).
+	TamperMoved
+
+	// TamperTransplanted means that the chunk carries a well-formed proof for its position that belongs to a
+	// tree with another root, which other chunks lead to as well, e.g. because they have been transplanted
+	// from another encoded image. A single transplanted chunk can't be told apart from a destroyed payload.
+	TamperTransplanted
+)
+
+// tampers are all kinds of manipulation in the order of their values.
+var tampers = []Tamper{TamperNone, TamperUnknown, TamperContent, TamperPayload, TamperMoved, TamperTransplanted}
+
+// String returns a short lower case description of the kind of manipulation.
+func (t Tamper) String() string {
+	switch t {
+	case TamperNone:
+		return "none"
+	case TamperUnknown:
+		return "unknown"
+	case TamperContent:
+		return "content modified"
+	case TamperPayload:
+		return "payload destroyed"
+	case TamperMoved:
+		return "moved"
+	case TamperTransplanted:
+		return "transplanted"
+	default:
+		return "unknown"
+	}
+}
+
+// ColorName returns the name of the color the chunk is marked with in the overlay image.
+func (t Tamper) ColorName() string {
+	switch t {
+	case TamperUnknown:
+		return "orange"
+	case TamperContent:
+		return "red"
+	case TamperPayload:
+		return "yellow"
+	case TamperMoved:
+		return "blue"
+	case TamperTransplanted:
+		return "magenta"
+	default:
+		return "none"
+	}
+}
+
+// Color returns the color the chunk is marked with in the overlay image. Chunks whose content has been
+// edited are marked in red, chunks with a damaged payload in yellow.
+func (t Tamper) Color() color.RGBA {
+	switch t {
+	case TamperUnknown:
+		return color.RGBA{R: 255, G: 128, A: 255}
+	case TamperContent:
+		return color.RGBA{R: 255, A: 255}
+	case TamperPayload:
+		return color.RGBA{R: 255, G: 255, A: 255}
+	case TamperMoved:
+		return color.RGBA{B: 255, A: 255}
+	case TamperTransplanted:
+		return color.RGBA{R: 255, B: 255, A: 255}
+	default:
+		return color.RGBA{}
+	}
+}
+
+// ChunkReport is the verification result of a single chunk.
+type ChunkReport struct {
+	// Index is the position of the chunk in the list of all chunks of the image.
+	Index int
+
+	// Level is the level of the chunk in the quadtree layout (see Options.Levels), 0 being the coarsest.
+	// It is always 0 for a single grid.
+	Level int
+
+	// Column and Row are the position of the chunk in the grid of chunks of its level.
+	Column int
+	Row    int
+
+	// Bounds are the pixel bounds of the chunk in the image.
+	Bounds image.Rectangle
+
+	// Root is the Merkle root the chunk leads to. It is nil if the proof of the chunk is invalid.
+	Root []byte
+
+	// Status tells whether the chunk leads to the Merkle root of the image.
+	Status ChunkStatus
+
+	// Tamper is the kind of manipulation of a chunk that doesn't lead to the root.
+	Tamper Tamper
+
+	// MovedFrom is the index of the position the chunk has been moved from if Tamper is TamperMoved.
+	MovedFrom int
+
+	// Changed are the pixel bounds of the sub-blocks of a chunk with modified content whose embedded hashes
+	// don't match (see Options.SubBlocks). It is empty if the changes couldn't be localised.
+	Changed []image.Rectangle
+}
+
+// RootCount is the number of chunks that lead to a Merkle root.
+type RootCount struct {
+	Root  []byte
+	Count int
+}
+
+// SignatureReport is the verification result of the signature of the Merkle root (see Options.SigningKey).
+type SignatureReport struct {
+	// Recovered is false if the signature could not be read from the chunks that lead to the root.
+	Recovered bool
+
+	// KeyID is the ID of the key the root was signed with.
+	KeyID []byte
+
+	// Signer is the name of the trusted key with that ID. It is empty if the key is not trusted.
+	Signer string
+
+	// Verified is true if the signature of the root was made by the trusted key.
+	Verified bool
+
+	// Required is true if trusted keys were given, so the root has to be signed by one of them.
+	Required bool
+}
+
+// Report is the verification result of an encoded image.
+type Report struct {
+	// Options are the options the image was encoded with. The keys are never part of the report.
+	Options Options
+
+	// Status is the outcome of the verification.
+	Status Status
+
+	// Root is the Merkle root the chunks are judged against. It is either the trusted root or the root most
+	// chunks lead to. It is nil if no chunk leads to any root and no root is trusted.
+	Root []byte
+
+	// Trusted is true if the root was given (see DecodeOptions.Root) instead of being chosen by majority.
+	Trusted bool
+
+	// Chunks holds the results of all chunks in the order of their indices.
+	Chunks []ChunkReport
+
+	// Roots is the histogram of the roots the chunks lead to, the most frequent root first.
+	Roots []RootCount
+
+	// Signature is the verification result of the signature of the root. It is nil if no trusted keys were
+	// given and the image isn't signed or no chunk leads to the root.
+	Signature *SignatureReport
+
+	// Overlay is the image with the chunks that don't lead to the root marked in the color of their kind of
+	// manipulation (see Tamper.Color). It is nil unless the image has been tampered with.
+	Overlay *image.RGBA
+}
+
+// Tampered returns the results of the chunks that don't lead to the root.
+func (r *Report) Tampered() []ChunkReport {
+	var tampered []ChunkReport
+	for _, c := range r.Chunks {
+		if c.Status != ChunkValid {
+			tampered = append(tampered, c)
+		}
+	}
+	return tampered
+}
+
+// TamperCounts returns the number of chunks per kind of manipulation. Kinds without chunks are omitted.
+func (r *Report) TamperCounts() map[Tamper]int {
+	counts := map[Tamper]int{}
+	for _, c := range r.Chunks {
+		counts[c.Tamper]++
+	}
+	return counts
+}
+
+// StatusCounts returns the number of chunks per status. Statuses without chunks are omitted.
+func (r *Report) StatusCounts() map[ChunkStatus]int {
+	counts := map[ChunkStatus]int{}
+	for _, c := range r.Chunks {
+		counts[c.Status]++
+	}
+	return counts
+}
+
+// rootHistogram counts how many of the given chunks lead to each root, the most frequent root first.
+// Chunks whose proof can't be followed are not counted.
+func rootHistogram(chunks []ChunkReport) []RootCount {
+	counts := map[string]int{}
+	for _, c := range chunks {
+		if c.Root != nil {
+			counts[string(c.Root)]++
+		}
+	}
+
+	roots := make([]RootCount, 0, len(counts))
+	for root, count := range counts {
+		roots = append(roots, RootCount{Root: []byte(root), Count: count})
+	}
+
+	sort.Slice(roots, func(i, j int) bool {
+		if roots[i].Count != roots[j].Count {
+			return roots[i].Count > roots[j].Count
+		}
+		return bytes.Compare(roots[i].Root, roots[j].Root) < 0
+	})
+
+	return roots
+}
+
+// Log logs the outcome of the verification.
+func (r *Report) Log() {
+	root := hex.EncodeToString(r.Root)
+
+	if r.Trusted && len(r.Roots) > 0 && !bytes.Equal(r.Roots[0].Root, r.Root) {
+		log.Println("The Merkle Root of most chunks is not the trusted one:", hex.EncodeToString(r.Roots[0].Root))
+	}
+
+	if s := r.Signature; s != nil {
+		if !r.Options.Signed() {
+			log.Println("The Merkle Root is not signed, so none of the trusted keys vouches for it!")
+		} else if !s.Recovered {
+			log.Println("The signature of the Merkle Root could not be recovered from the chunks that lead to it!")
+		} else if s.Signer == "" && s.Required {
+			log.Printf("The Merkle Root is signed by an untrusted key with ID %s!\n", hex.EncodeToString(s.KeyID))
+		} else if s.Signer == "" {
+			log.Printf("The Merkle Root is signed by an untrusted key with ID %s, provide trusted keys to verify the signature\n", hex.EncodeToString(s.KeyID))
+		} else if !s.Verified {
+			log.Printf("The signature of the Merkle Root is invalid! The image has been encoded again or the signature of %s has been forged\n", s.Signer)
+		} else {
+			log.Printf("The Merkle Root is signed by %s (key ID %s)\n", s.Signer, hex.EncodeToString(s.KeyID))
+		}
+	}
+
+	switch r.Status {
+	case StatusNotEncoded:
+		log.Println("This image has not been encoded or it has been saved again, e.g. as a JPEG. It carries no options and no two chunks lead to the same Merkle Root.")
+		return
+	case StatusClean:
+		if r.Trusted {
+			log.Println("This image has not been tampered with. All chunks have the trusted Merkle Root:", root)
+		} else {
+			log.Println("This image has not been tampered with. All chunks have the same Merkle Root:", root)
+		}
+		return
+	case StatusUntrustedSignature:
+		log.Println("All chunks have the same Merkle Root, but no trusted key vouches for it. This image may have been encoded again:", root)
+		return
+	case StatusNoCommonRoot:
+		if r.Options.Keyed() || r.Options.Authenticated() {
+			log.Println("No two chunks lead to the same Merkle Root. Are the given keys correct?")
+		} else {
+			log.Println("No two chunks lead to the same Merkle Root. The embedded data of the whole image has been destroyed!")
+		}
+		return
+	case StatusNoTrustedMatch:
+		log.Println("No chunk matches the trusted Merkle Root. This image is not the trusted one or has been tampered with completely! RootHashes:")
+	default:
+		if len(r.Roots) > 1 {
+			log.Println("Found multiple Merkle Roots. This image has been tampered with! RootHashes:")
+		} else {
+			log.Println("Found chunks with damaged Merkle proofs. This image has been tampered with! RootHashes:")
+		}
+	}
+
+	log.Println("Count\tRoot")
+	for _, rc := range r.Roots {
+		log.Printf("%5d\t%s\n", rc.Count, hex.EncodeToString(rc.Root))
+	}
+
+	counts := r.StatusCounts()
+	log.Println("Count\tReason")
+	for _, s := range chunkStatuses {
+		if s != ChunkValid && counts[s] > 0 {
+			log.Printf("%5d\t%s\n", counts[s], s)
+		}
+	}
+
+	if r.Options.levels() > 1 {
+		total := make([]int, r.Options.levels())
+		tampered := make([]int, r.Options.levels())
+		for _, c := range r.Chunks {
+			total[c.Level]++
+			if c.Status != ChunkValid {
+				tampered[c.Level]++
+			}
+		}
+		for level := range total {
+			log.Printf("Level %d: %d of %d chunks don't lead to the root\n", level, tampered[level], total[level])
+		}
+	}
+
+	tamperCounts := r.TamperCounts()
+	log.Println("Count\tManipulation (overlay color)")
+	for _, t := range tampers {
+		if t != TamperNone && tamperCounts[t] > 0 {
+			log.Printf("%5d\t%s (%s)\n", tamperCounts[t], t, t.ColorName())
+		}
+	}
+	for _, c := range r.Chunks {
+		if c.Tamper == TamperMoved {
+			log.Printf("Chunk %d (column %d, row %d) has been moved from chunk %d\n", c.Index, c.Column, c.Row, c.MovedFrom)
+		}
+		for _, changed := range c.Changed {
+			log.Printf("Chunk %d (column %d, row %d) has been modified within %v\n", c.Index, c.Column, c.Row, changed)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package chunk
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// KeyIDSize is the number of bytes of a key ID, the truncated SHA-256 hash of an Ed25519 public key.
+const KeyIDSize = 8
+
+// signatureBlockSize is the number of bytes of the key ID and the signature that are embedded in the
+// spare capacity of the chunks.
+const signatureBlockSize = KeyIDSize + ed25519.SignatureSize
+
+// signatureContext is signed in front of the Merkle root, so a signature of a root can't be mistaken for a
+// signature of anything else made with the same key.
+const signatureContext = "image-stego merkle root\x00"
+
+// TrustedKey is a public key of a signer whose signatures of Merkle roots are trusted.
+type TrustedKey struct {
+	// Name identifies the signer in the verification report.
+	Name string
+
+	// PublicKey is the Ed25519 public key of the signer.
+	PublicKey ed25519.PublicKey
+}
+
+// KeyID returns the ID of the given public key, which is embedded in the image together with a signature.
+func KeyID(pub ed25519.PublicKey) []byte {
+	sum := sha256.Sum256(pub)
+	return sum[:KeyIDSize]
+}
+
+// GenerateKeyPair generates a new Ed25519 key pair and writes the private key to <dir>/<name>.key and the
+// public key to <dir>/<name>.pub. The private key file holds the hex encoded seed of the key, the public key
+// file holds a line of the hex encoded public key and the name, so it can be appended to a trusted keys file
+// (see ReadTrustedKeys). It returns the path of the public key file.
+func GenerateKeyPair(dir string, name string) (string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	privFilepath := path.Join(dir, name+".key")
+	if _, err = os.Stat(privFilepath); err == nil {
+		return "", fmt.Errorf("private key %s already exists", privFilepath)
+	}
+
+	err = ioutil.WriteFile(privFilepath, []byte(hex.EncodeToString(priv.Seed())+"\n"), 0600)
+	if err != nil {
+		return "", err
+	}
+
+	pubFilepath := path.Join(dir, name+".pub")
+	err = ioutil.WriteFile(pubFilepath, []byte(hex.EncodeToString(pub)+" "+name+"\n"), 0644)
+	if err != nil {
+		return "", err
+	}
+
+	return pubFilepath, nil
+}
+
+// ReadSigningKey reads the private key from a file that was written by GenerateKeyPair.
+func ReadSigningKey(filename string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid private key in %s", filename)
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ReadTrustedKeys reads the public keys of trusted signers from the given file. Each line holds a hex encoded
+// public key optionally followed by the name of the signer, like the public key files that are written by
+// GenerateKeyPair. Empty lines and lines starting with # are ignored. Keys without a name are named by
+// their key ID.
+func ReadTrustedKeys(filename string) ([]TrustedKey, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []TrustedKey
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		pub, err := hex.DecodeString(fields[0])
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key in %s:%d", filename, line)
+		}
+
+		name := strings.TrimSpace(strings.TrimPrefix(text, fields[0]))
+		if name == "" {
+			name = hex.EncodeToString(KeyID(pub))
+		}
+
+		keys = append(keys, TrustedKey{Name: name, PublicKey: pub})
+	}
+
+	return keys, scanner.Err()
+}
+
+// signRoot returns the signature block of the given Merkle root: the ID of the key followed by the signature.
+func signRoot(key ed25519.PrivateKey, root []byte) []byte {
+	block := KeyID(key.Public().(ed25519.PublicKey))
+	return append(block, ed25519.Sign(key, append([]byte(signatureContext), root...))...)
+}
+
+// errUnknownSigner is returned if the signature block was made with a key that isn't trusted.
+var errUnknownSigner = errors.New("unknown signer")
+
+// verifyRootSignature verifies the given signature block of the given Merkle root with the trusted key whose
+// ID is recorded in the block. It returns the trusted key or an error wrapping errUnknownSigner if no key
+// with that ID is trusted.
+func verifyRootSignature(block []byte, root []byte, keys []TrustedKey) (TrustedKey, error) {
+	if len(block) != signatureBlockSize {
+		return TrustedKey{}, errors.New("invalid signature length")
+	}
+
+	keyID, sig := block[:KeyIDSize], block[KeyIDSize:]
+	for _, key := range keys {
+		if !bytes.Equal(KeyID(key.PublicKey), keyID) {
+			continue
+		}
+
+		if !ed25519.Verify(key.PublicKey, append([]byte(signatureContext), root...), sig) {
+			return key, fmt.Errorf("invalid signature of %s", key.Name)
+		}
+		return key, nil
+	}
+
+	return TrustedKey{}, fmt.Errorf("%w with key ID %s", errUnknownSigner, hex.EncodeToString(keyID))
+}
+
+// spareBytes returns the number of whole bytes that are left in the chunk after its Merkle proof and its
+// sub-block hashes. The length of the proof is fixed by the position of the chunk in the tree and the number
+// of sub-blocks by its size, so the spare capacity can be determined without reading the proof, even if the
+// chunk is manipulated.
+func spareBytes(chunk *Chunk, chunkCount int, opts Options) int {
+	used := proofBitLength(chunk.Index, chunkCount, opts) + subBlockBitLength(chunk, chunkCount, opts)
+	if used >= chunk.LSBCount() {
+		return 0
+	}
+	return (chunk.LSBCount() - used) / BitsPerByte
+}
+
+// writeSignatureBlock writes the given block repeatedly to the spare bytes of the chunks in the order of
+// their indices. The chunks must be in that order and their Merkle proofs must already be written.
+// It returns the number of complete copies of the block or an error if not even one copy fits.
+func writeSignatureBlock(chunks []*Chunk, block []byte, opts Options) (int, error) {
+	n := 0
+	for _, chunk := range chunks {
+		for i := spareBytes(chunk, len(chunks), opts); i > 0; i-- {
+			if _, err := chunk.Write([]byte{block[n%len(block)]}); err != nil {
+				return 0, err
+			}
+			n++
+		}
+	}
+
+	if n < len(block) {
+		return 0, fmt.Errorf("the chunks have %d spare bytes but the signature needs %d", n, len(block))
+	}
+	return n / len(block), nil
+}
+
+// readSignatureBlock reads the block that was written by writeSignatureBlock from the given chunks whose
+// proofs have already been read. As the block is repeated every byte is chosen by majority vote among the
+// trusted chunks (e.g. the ones that lead to the Merkle root), so the block survives the manipulation of
+// some chunks. The chunks must be in the order of their indices. It returns false if not every byte of the
+// block is found in a trusted chunk.
+func readSignatureBlock(chunks []*Chunk, trusted []bool, opts Options) ([]byte, bool) {
+	votes := make([]map[byte]int, signatureBlockSize)
+	for i := range votes {
+		votes[i] = map[byte]int{}
+	}
+
+	n := 0
+	for i, chunk := range chunks {
+		spare := spareBytes(chunk, len(chunks), opts)
+		if !trusted[i] {
+			n += spare
+			continue
+		}
+
+		for ; spare > 0; spare-- {
+			b := make([]byte, 1)
+			if _, err := chunk.Read(b); err != nil {
+				break
+			}
+			votes[n%signatureBlockSize][b[0]]++
+			n++
+		}
+		n += spare
+	}
+
+	block := make([]byte, signatureBlockSize)
+	for i, counts := range votes {
+		if len(counts) == 0 {
+			return nil, false
+		}
+
+		best := 0
+		for b, count := range counts {
+			if count > best || (count == best && b < block[i]) {
+				best = count
+				block[i] = b
+			}
+		}
+	}
+
+	return block, true
+}
@@ -0,0 +1,127 @@
+package chunk
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"image"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateKeyPair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pubFilepath, err := GenerateKeyPair(dir, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, path.Join(dir, "alice.pub"), pubFilepath)
+
+	priv, err := ReadSigningKey(path.Join(dir, "alice.key"))
+	require.NoError(t, err)
+
+	keys, err := ReadTrustedKeys(pubFilepath)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "alice", keys[0].Name)
+	assert.Equal(t, priv.Public(), keys[0].PublicKey)
+
+	// An existing private key is never overwritten
+	_, err = GenerateKeyPair(dir, "alice")
+	assert.Error(t, err)
+}
+
+func TestReadTrustedKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-stego")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pub := make([]byte, ed25519.PublicKeySize)
+	filepath := path.Join(dir, "trusted")
+	content := "# trusted signers\n\n" + hex.EncodeToString(pub) + " Alice Doe\n" + hex.EncodeToString(pub) + "\n"
+	require.NoError(t, ioutil.WriteFile(filepath, []byte(content), 0644))
+
+	keys, err := ReadTrustedKeys(filepath)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "Alice Doe", keys[0].Name)
+	assert.Equal(t, hex.EncodeToString(KeyID(pub)), keys[1].Name)
+
+	require.NoError(t, ioutil.WriteFile(filepath, []byte("abcd alice\n"), 0644))
+	_, err = ReadTrustedKeys(filepath)
+	assert.Error(t, err)
+}
+
+func TestVerifyRootSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	root := []byte("root")
+	block := signRoot(priv, root)
+	require.Len(t, block, signatureBlockSize)
+
+	keys := []TrustedKey{{Name: "other", PublicKey: otherPub}, {Name: "alice", PublicKey: pub}}
+	key, err := verifyRootSignature(block, root, keys)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", key.Name)
+
+	_, err = verifyRootSignature(block, []byte("other root"), keys)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, errUnknownSigner))
+
+	_, err = verifyRootSignature(block, root, keys[:1])
+	assert.True(t, errors.Is(err, errUnknownSigner))
+}
+
+func TestWriteReadSignatureBlock(t *testing.T) {
+	img := blackImage(32, 32)
+	opts := DefaultOptions()
+	opts.ProofHashBits = 32
+
+	newChunks := func() []*Chunk {
+		chunks := make([]*Chunk, 4)
+		for i := range chunks {
+			bounds := image.Rect(0, 0, 16, 16).Add(image.Pt(16*(i%2), 16*(i/2)))
+			chunks[i] = &Chunk{Image: img.SubImage(bounds).(*image.NRGBA), Index: i}
+		}
+		return chunks
+	}
+
+	// Every chunk holds 16*16*3 bits, the number of hashes and two proof hashes take 2+2*33 bits
+	chunks := newChunks()
+	assert.Equal(t, (768-68)/BitsPerByte, spareBytes(chunks[0], len(chunks), opts))
+
+	block := make([]byte, signatureBlockSize)
+	for i := range block {
+		block[i] = byte(i + 1)
+	}
+
+	copies, err := writeSignatureBlock(chunks, block, opts)
+	require.NoError(t, err)
+	assert.Equal(t, 4*87/signatureBlockSize, copies)
+
+	// The block is recovered by majority vote although a trusted chunk is manipulated
+	img.Pix[0] ^= 1
+	read, found := readSignatureBlock(newChunks(), []bool{true, true, true, true}, opts)
+	require.True(t, found)
+	assert.Equal(t, block, read)
+
+	// Untrusted chunks are ignored
+	read, found = readSignatureBlock(newChunks(), []bool{false, false, true, false}, opts)
+	require.True(t, found)
+	assert.Equal(t, block, read)
+
+	_, found = readSignatureBlock(newChunks(), []bool{false, false, false, false}, opts)
+	assert.False(t, found)
+
+	_, err = writeSignatureBlock(newChunks()[:1], make([]byte, 100), opts)
+	assert.Error(t, err)
+}
@@ -0,0 +1,127 @@
+package chunk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+
+	"dennis-tra/image-stego/pkg/merkle"
+)
+
+// SubBlockHashBitLength is the number of bits of each sub-block hash (see Options.SubBlocks).
+const SubBlockHashBitLength = 16
+
+// MaxSubBlocks is the maximum number of sub-blocks along each side of a chunk.
+const MaxSubBlocks = 16
+
+// proofBitLength returns the number of payload bits that the Merkle proof of the chunk with the given index
+// occupies: the number of hashes followed by the side and the hash of each of them. A single chunk has no
+// proof but stores the root itself and its check value.
+func proofBitLength(index, chunkCount int, opts Options) int {
+	sides, err := merkle.ProofSides(index, chunkCount)
+	if err != nil {
+		return 0
+	}
+	if chunkCount == 1 {
+		return opts.Hash.BitLength() + RootCheckBitLength
+	}
+	return PathCountBitLength(chunkCount) + len(sides)*(MerkleSideBitLength+opts.ProofHashBitLength())
+}
+
+// subBlockGrid returns the number of sub-blocks along each side of the given chunk. It is the largest number
+// up to Options.SubBlocks whose hashes fit into the capacity the chunk has left after its Merkle proof and
+// that doesn't exceed the width or height of the chunk. Zero means that the chunk carries no sub-block hashes.
+func subBlockGrid(chunk *Chunk, chunkCount int, opts Options) int {
+	spare := chunk.LSBCount() - proofBitLength(chunk.Index, chunkCount, opts)
+
+	grid := opts.SubBlocks
+	for ; grid > 1; grid-- {
+		if grid*grid*SubBlockHashBitLength <= spare && grid <= chunk.Width() && grid <= chunk.Height() {
+			return grid
+		}
+	}
+	return 0
+}
+
+// subBlockBitLength returns the number of payload bits the sub-block hashes of the given chunk occupy.
+func subBlockBitLength(chunk *Chunk, chunkCount int, opts Options) int {
+	grid := subBlockGrid(chunk, chunkCount, opts)
+	return grid * grid * SubBlockHashBitLength
+}
+
+// subBlockBounds returns the bounds of the sub-blocks of the given chunk row by row. The chunk is divided into
+// grid x grid sub-blocks whose side lengths differ by one at most.
+func subBlockBounds(chunk *Chunk, grid int) []image.Rectangle {
+	b := chunk.Bounds()
+
+	var bounds []image.Rectangle
+	for sy := 0; sy < grid; sy++ {
+		for sx := 0; sx < grid; sx++ {
+			bounds = append(bounds, image.Rect(
+				b.Min.X+sx*b.Dx()/grid, b.Min.Y+sy*b.Dy()/grid,
+				b.Min.X+(sx+1)*b.Dx()/grid, b.Min.Y+(sy+1)*b.Dy()/grid,
+			))
+		}
+	}
+	return bounds
+}
+
+// subBlockHash returns the truncated hash of the sub-block with the given number and bounds of the given
+// chunk. Like the chunk hash it covers the position of the chunk and is an HMAC if a MAC key is set.
+func subBlockHash(chunk *Chunk, number int, bounds image.Rectangle) []byte {
+	h := chunk.hash().newFunc(chunk.MACKey)()
+	h.Write(chunk.position())
+
+	n := make([]byte, 4)
+	binary.BigEndian.PutUint32(n, uint32(number))
+	h.Write(n)
+
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			h.Write(chunk.contentAt(x, y))
+		}
+	}
+
+	return h.Sum(nil)[:SubBlockHashBitLength/BitsPerByte]
+}
+
+// writeSubBlockHashes writes the hashes of the sub-blocks of the given chunk right after its Merkle proof.
+func writeSubBlockHashes(chunk *Chunk, chunkCount int, opts Options) error {
+	for i, bounds := range subBlockBounds(chunk, subBlockGrid(chunk, chunkCount, opts)) {
+		if _, err := chunk.Write(subBlockHash(chunk, i, bounds)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSubBlockHashes reads the hashes of the sub-blocks of the given chunk whose Merkle proof has been read.
+func readSubBlockHashes(chunk *Chunk, chunkCount int, opts Options) ([][]byte, error) {
+	grid := subBlockGrid(chunk, chunkCount, opts)
+
+	hashes := make([][]byte, grid*grid)
+	for i := range hashes {
+		hashes[i] = make([]byte, SubBlockHashBitLength/BitsPerByte)
+		if _, err := chunk.Read(hashes[i]); err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
+// changedSubBlocks returns the pixel bounds of the sub-blocks of the given chunk whose hashes don't match the
+// given embedded ones. It returns nil if the chunk carries no sub-block hashes.
+func changedSubBlocks(chunk *Chunk, hashes [][]byte) []image.Rectangle {
+	grid := 0
+	for grid*grid < len(hashes) {
+		grid++
+	}
+
+	var changed []image.Rectangle
+	for i, bounds := range subBlockBounds(chunk, grid) {
+		if !bytes.Equal(subBlockHash(chunk, i, bounds), hashes[i]) {
+			changed = append(changed, pixelRect(chunk.Image, bounds))
+		}
+	}
+	return changed
+}
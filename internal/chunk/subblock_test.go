@@ -0,0 +1,21 @@
+package chunk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubBlockGrid(t *testing.T) {
+	opts := DefaultOptions()
+	opts.SubBlocks = 4
+	chunk := &Chunk{Image: blackImage(16, 16)}
+
+	// 16*16*3 bits minus the proof of two 257 bit hashes and 2 bits for their number leave 252 bits
+	assert.Equal(t, 3, subBlockGrid(chunk, 4, opts))
+	assert.Len(t, subBlockBounds(chunk, 3), 9)
+	assert.Equal(t, (768-516-144)/BitsPerByte, spareBytes(chunk, 4, opts))
+
+	opts.SubBlocks = 0
+	assert.Equal(t, 0, subBlockGrid(chunk, 4, opts))
+}
@@ -0,0 +1,196 @@
+package chunk
+
+import (
+	"bytes"
+	"image"
+
+	"dennis-tra/image-stego/pkg/merkle"
+)
+
+// knownNodes holds the truncated hashes of the nodes of the tree of the root that are known from the chunks
+// that lead to it: their leaf hashes, the nodes on their paths to the root and the hashes of their proofs.
+// The nodes are identified by the range [start, end) of their leaves.
+type knownNodes map[[2]int][]byte
+
+// newKnownNodes collects the known nodes of the tree from the trusted chunks.
+func newKnownNodes(leaves [][]byte, proofs []*embeddedProof, trusted []bool, opts Options) (knownNodes, error) {
+	hasher := opts.merkleHasher()
+	proofHashSize := opts.ProofHashBitLength() / BitsPerByte
+	chunkCount := len(leaves)
+
+	nodes := knownNodes{}
+	for i, ok := range trusted {
+		if !ok {
+			continue
+		}
+
+		proof, err := proofs[i].forIndex(i, chunkCount)
+		if err != nil {
+			return nil, err
+		}
+
+		ranges, err := merkle.ProofRanges(i, chunkCount)
+		if err != nil {
+			return nil, err
+		}
+
+		node, hash := [2]int{i, i + 1}, leaves[i]
+		nodes[node] = hash[:proofHashSize]
+		for l, sibling := range ranges {
+			nodes[sibling] = proof[l]
+			if sibling[0] >= node[1] {
+				node, hash = [2]int{node[0], sibling[1]}, hasher.HashChildren(hash, proof[l])
+			} else {
+				node, hash = [2]int{sibling[0], node[1]}, hasher.HashChildren(proof[l], hash)
+			}
+			nodes[node] = hash[:proofHashSize]
+		}
+	}
+
+	return nodes, nil
+}
+
+// agree returns true if the given proof of the leaf with the given index doesn't contradict the known nodes.
+func (n knownNodes) agree(proof [][]byte, index, chunkCount int) bool {
+	ranges, err := merkle.ProofRanges(index, chunkCount)
+	if err != nil || len(ranges) != len(proof) {
+		return false
+	}
+
+	for l, r := range ranges {
+		if known, ok := n[r]; ok && !bytes.Equal(known, proof[l]) {
+			return false
+		}
+	}
+	return true
+}
+
+// positionKey identifies the positions an embedded proof may belong to: the sides of the proof hashes, which
+// also fix their number, and the size of the chunk.
+type positionKey struct {
+	sides string
+	size  image.Point
+}
+
+// newPositionKey returns the key of the given sides and chunk size.
+func newPositionKey(sides []bool, size image.Point) positionKey {
+	key := make([]byte, len(sides))
+	for i, side := range sides {
+		if side {
+			key[i] = 1
+		}
+	}
+	return positionKey{sides: string(key), size: size}
+}
+
+// positionIndex holds the indices of the chunks by their position key, so the positions an embedded proof
+// may belong to are looked up instead of trying every chunk.
+type positionIndex map[positionKey][]int
+
+// newPositionIndex indexes the positions of all chunks of the report.
+func newPositionIndex(chunks []ChunkReport) (positionIndex, error) {
+	index := positionIndex{}
+	for _, c := range chunks {
+		sides, err := merkle.ProofSides(c.Index, len(chunks))
+		if err != nil {
+			return nil, err
+		}
+
+		key := newPositionKey(sides, c.Bounds.Size())
+		index[key] = append(index[key], c.Index)
+	}
+	return index, nil
+}
+
+// candidates returns the indices of the chunks of the given size whose sides match the given embedded proof.
+func (idx positionIndex) candidates(proof *embeddedProof, size image.Point) []int {
+	if len(proof.sides) < proof.pathCount {
+		return nil
+	}
+	return idx[newPositionKey(proof.sides[:proof.pathCount], size)]
+}
+
+// classifyTampering classifies the manipulation of every chunk of the report that doesn't lead to the root.
+// The chunks that lead to it are marked as trusted. At least one chunk must be trusted, unless the image is a
+// single chunk that is judged against the root it stores.
+func classifyTampering(report *Report, chunks []*Chunk, leaves [][]byte, proofs []*embeddedProof, trusted []bool, opts Options) error {
+	nodes, err := newKnownNodes(leaves, proofs, trusted, opts)
+	if err != nil {
+		return err
+	}
+
+	positions, err := newPositionIndex(report.Chunks)
+	if err != nil {
+		return err
+	}
+
+	rootCounts := map[string]int{}
+	for _, rc := range report.Roots {
+		rootCounts[string(rc.Root)] = rc.Count
+	}
+
+	for i := range report.Chunks {
+		c := &report.Chunks[i]
+		if c.Status == ChunkValid {
+			continue
+		}
+
+		if c.Tamper, c.MovedFrom, err = classifyChunk(report, chunks[i], proofs[i], nodes, positions, rootCounts[string(c.Root)], opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// classifyChunk returns the kind of manipulation of the given chunk that doesn't lead to the root and the
+// index of the position it has been moved from, if it has been moved.
+//
+// A chunk has been moved from another position if its embedded proof belongs to that position and its hash
+// at that position leads to the root. Otherwise, if the embedded proof belongs to the position of the chunk,
+// it's either the original proof, so the content has been modified, or a proof that contradicts the known
+// nodes of the tree. The latter has been transplanted if other chunks lead to the same root as the chunk
+// (rootCount), which tells that the root belongs to another tree. Damaged payload bits, which the proof
+// hashes consist of for the most part, lead to a root of its own instead. So in any other case the payload
+// has been destroyed. The positions the proof may belong to are looked up in the given index.
+func classifyChunk(report *Report, chunk *Chunk, proof *embeddedProof, nodes knownNodes, positions positionIndex, rootCount int, opts Options) (Tamper, int, error) {
+	if proof == nil {
+		return TamperPayload, 0, nil
+	}
+
+	hasher := opts.merkleHasher()
+	chunkCount := len(report.Chunks)
+	bounds := report.Chunks[chunk.Index].Bounds
+
+	for _, j := range positions.candidates(proof, bounds.Size()) {
+		other := report.Chunks[j]
+		if j == chunk.Index {
+			continue
+		}
+
+		hashes, err := proof.forIndex(j, chunkCount)
+		if err != nil || !nodes.agree(hashes, j, chunkCount) {
+			continue
+		}
+
+		leaf, err := chunk.calculateHashAt(j, other.Bounds)
+		if err != nil {
+			return TamperUnknown, 0, err
+		}
+
+		if merkle.VerifyProof(hasher, j, chunkCount, leaf, hashes, report.Root) {
+			return TamperMoved, j, nil
+		}
+	}
+
+	hashes, err := proof.forIndex(chunk.Index, chunkCount)
+	if err != nil {
+		return TamperPayload, 0, nil
+	} else if nodes.agree(hashes, chunk.Index, chunkCount) {
+		return TamperContent, 0, nil
+	} else if rootCount > 1 {
+		return TamperTransplanted, 0, nil
+	}
+
+	return TamperPayload, 0, nil
+}
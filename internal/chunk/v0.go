@@ -0,0 +1,109 @@
+package chunk
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"io"
+	"math"
+
+	"dennis-tra/image-stego/pkg/bit"
+)
+
+// This file contains the reader of the images that were encoded by the first release, which didn't record
+// any options in the image (format version 0). Its chunks hold a Merkle proof of full SHA-256 hashes in the
+// least significant bits of the R, G and B values of the premultiplied pixels:
+//
+//   - the number of proof hashes in 8 bits
+//   - for every proof hash a side byte, 0 if the hash is the left child and 1 if it is the right one, and the 32 hash bytes
+//
+// The leaves are the plain SHA-256 hashes of the chunk content and the nodes the plain SHA-256 hashes of their
+// concatenated children. The last node of a level with an odd number of nodes was duplicated, which doesn't
+// matter for following a proof.
+
+const (
+	// v0HashBitLength is the number of bits of a proof hash.
+	v0HashBitLength = 256
+
+	// v0SideBitLength is the number of bits of the side of a proof hash.
+	v0SideBitLength = 8
+
+	// v0PathCountBitLength is the number of bits of the number of proof hashes.
+	v0PathCountBitLength = 8
+
+	// v0PixelBits is the number of payload bits of a pixel.
+	v0PixelBits = 3
+)
+
+// v0Image returns the pixels of the given image as the first release read them: premultiplied 8-bit RGBA
+// values. They are wrapped as an *image.NRGBA, so that chunks read and hash the values as they are.
+func v0Image(img Image) *image.NRGBA {
+	rgba := ImageToRGBA(pixelImage(img))
+	return &image.NRGBA{Pix: rgba.Pix, Stride: rgba.Stride, Rect: rgba.Rect}
+}
+
+// v0Bounds returns the grid of chunks of the first release (see legacyLayout).
+func v0Bounds(img Image) [][]image.Rectangle {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	cols, rows := legacyLayout(width, height)
+	return gridBounds(width, height, cols, rows)
+}
+
+// v0NeededBits returns the number of bits that are needed to store a proof of the first release for the given
+// number of chunks.
+func v0NeededBits(chunkCount int) int {
+	hashesPerChunk := int(math.Ceil(math.Log2(float64(chunkCount))))
+	return hashesPerChunk*(v0HashBitLength+v0SideBitLength) + v0PathCountBitLength
+}
+
+// v0Hash returns the leaf hash of the given chunk of the first release: the SHA-256 hash of the R, G and B
+// values with their least significant bit set to 0, column by column. Neither the position nor the alpha
+// values are hashed.
+func v0Hash(chunk *Chunk) []byte {
+	h := sha256.New()
+	for x := chunk.MinX(); x < chunk.MaxX(); x++ {
+		for y := chunk.MinY(); y < chunk.MaxY(); y++ {
+			i := chunk.PixOffset(x, y)
+			pix := chunk.pix()[i : i+3]
+			h.Write([]byte{bit.WithLSB(pix[0], false), bit.WithLSB(pix[1], false), bit.WithLSB(pix[2], false)})
+		}
+	}
+	return h.Sum(nil)
+}
+
+// v0ChunkRoot returns the Merkle root that results from the hash of the given chunk and the proof that is
+// embedded in it by the first release. Like the first release it stops following the proof at a side that is
+// neither 0 nor 1 or at the end of the chunk, e.g. because the number of hashes has been manipulated, so
+// that the chunk leads to a different root. Only a chunk that is too small to even hold the number of hashes returns an
+// error wrapping errCorruptHeader.
+func v0ChunkRoot(chunk *Chunk) ([]byte, error) {
+	pathCount, err := chunk.ReadBits(v0PathCountBitLength)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errCorruptHeader, err)
+	}
+
+	prevHash := v0Hash(chunk)
+	for i := 0; i < int(pathCount); i++ {
+		side, err := chunk.ReadBits(v0SideBitLength)
+		if err != nil || side > 1 {
+			break
+		}
+
+		data := make([]byte, v0HashBitLength/BitsPerByte)
+		if _, err = io.ReadFull(chunk, data); err != nil {
+			break
+		}
+
+		h := sha256.New()
+		if side == 0 {
+			h.Write(data)
+			h.Write(prevHash)
+		} else {
+			h.Write(prevHash)
+			h.Write(data)
+		}
+		prevHash = h.Sum(nil)
+	}
+
+	return prevHash, nil
+}
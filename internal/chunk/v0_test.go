@@ -0,0 +1,40 @@
+package chunk
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The example images in the docs folder were encoded by the first release.
+const v0CarRoot = "278cba1daf96d84165f8aa69d184e63df5c79f3a4c31cc6864e148c0317c713d"
+
+func TestDecode_V0Clean(t *testing.T) {
+	report, err := Decode("../../docs/car.png", DecodeOptions{})
+	require.NoError(t, err)
+
+	assert.Equal(t, StatusClean, report.Status)
+	assert.Equal(t, v0CarRoot, hex.EncodeToString(report.Root))
+	require.Len(t, report.Roots, 1)
+	assert.Equal(t, len(report.Chunks), report.Roots[0].Count)
+	assert.Greater(t, len(report.Chunks), 1)
+	assert.Nil(t, report.Overlay)
+}
+
+func TestDecode_V0Tampered(t *testing.T) {
+	root, err := hex.DecodeString(v0CarRoot)
+	require.NoError(t, err)
+
+	report, err := Decode("../../docs/car.tampered.png", DecodeOptions{Root: root})
+	require.NoError(t, err)
+
+	assert.Equal(t, StatusTampered, report.Status)
+	assert.NotEmpty(t, report.Tampered())
+	assert.Less(t, len(report.Tampered()), len(report.Chunks)/10)
+	for _, c := range report.Tampered() {
+		assert.Equal(t, ChunkRootMismatch, c.Status)
+	}
+	assert.NotNil(t, report.Overlay)
+}
@@ -14,3 +14,34 @@ func WithLSB(b byte, bit bool) byte {
 func GetLSB(b byte) bool {
 	return b%2 != 0
 }
+
+// GetBit returns the bit at position i of the given byte, while position 0 is the least significant bit.
+func GetBit(b byte, i uint8) bool {
+	return b&(1<<i) != 0
+}
+
+// WithBit returns the given byte with the bit at position i set to the given bit value,
+// while position 0 is the least significant bit.
+func WithBit(b byte, i uint8, bit bool) byte {
+	if bit {
+		return b | 1<<i
+	} else {
+		return b &^ (1 << i)
+	}
+}
+
+// GetLowBits returns the k least significant bits of the given byte.
+func GetLowBits(b byte, k uint8) byte {
+	return b & lowMask(k)
+}
+
+// WithLowBits returns the given byte with its k least significant bits replaced by
+// the k least significant bits of v.
+func WithLowBits(b byte, k uint8, v byte) byte {
+	return b&^lowMask(k) | v&lowMask(k)
+}
+
+// lowMask returns a byte with the k least significant bits set to one.
+func lowMask(k uint8) byte {
+	return byte(1<<k - 1)
+}
@@ -45,3 +45,86 @@ func TestWithLSB(t *testing.T) {
 		})
 	}
 }
+
+func TestGetBit(t *testing.T) {
+	tests := []struct {
+		byte byte
+		i    uint8
+		want bool
+	}{
+		{byte: 0b00000000, i: 0, want: false},
+		{byte: 0b00000001, i: 0, want: true},
+		{byte: 0b00000100, i: 2, want: true},
+		{byte: 0b11111011, i: 2, want: false},
+		{byte: 0b10000000, i: 7, want: true},
+	}
+	for _, tt := range tests {
+		name := fmt.Sprintf("bit %d of %08b should be %t", tt.i, tt.byte, tt.want)
+		t.Run(name, func(t *testing.T) {
+			got := GetBit(tt.byte, tt.i)
+			assert.Equal(t, tt.want, got, "GetBit() = %v, want %v", got, tt.want)
+		})
+	}
+}
+
+func TestWithBit(t *testing.T) {
+	tests := []struct {
+		byte byte
+		i    uint8
+		bit  bool
+		want byte
+	}{
+		{byte: 0b00000000, i: 0, bit: true, want: 0b00000001},
+		{byte: 0b00000000, i: 3, bit: true, want: 0b00001000},
+		{byte: 0b11111111, i: 3, bit: false, want: 0b11110111},
+		{byte: 0b11111111, i: 7, bit: true, want: 0b11111111},
+	}
+	for _, tt := range tests {
+		name := fmt.Sprintf("setting bit %d of %08b to %t should be %08b", tt.i, tt.byte, tt.bit, tt.want)
+		t.Run(name, func(t *testing.T) {
+			got := WithBit(tt.byte, tt.i, tt.bit)
+			assert.Equal(t, tt.want, got, "WithBit() = %v, want %v", got, tt.want)
+		})
+	}
+}
+
+func TestGetLowBits(t *testing.T) {
+	tests := []struct {
+		byte byte
+		k    uint8
+		want byte
+	}{
+		{byte: 0b11111111, k: 1, want: 0b00000001},
+		{byte: 0b11111110, k: 2, want: 0b00000010},
+		{byte: 0b10101010, k: 4, want: 0b00001010},
+		{byte: 0b10101010, k: 0, want: 0b00000000},
+	}
+	for _, tt := range tests {
+		name := fmt.Sprintf("%d low bits of %08b should be %08b", tt.k, tt.byte, tt.want)
+		t.Run(name, func(t *testing.T) {
+			got := GetLowBits(tt.byte, tt.k)
+			assert.Equal(t, tt.want, got, "GetLowBits() = %v, want %v", got, tt.want)
+		})
+	}
+}
+
+func TestWithLowBits(t *testing.T) {
+	tests := []struct {
+		byte byte
+		k    uint8
+		v    byte
+		want byte
+	}{
+		{byte: 0b11111111, k: 1, v: 0, want: 0b11111110},
+		{byte: 0b11111111, k: 3, v: 0b010, want: 0b11111010},
+		{byte: 0b00000000, k: 4, v: 0b11111111, want: 0b00001111},
+		{byte: 0b10101010, k: 2, v: 0b01, want: 0b10101001},
+	}
+	for _, tt := range tests {
+		name := fmt.Sprintf("setting %d low bits of %08b to %08b should be %08b", tt.k, tt.byte, tt.v, tt.want)
+		t.Run(name, func(t *testing.T) {
+			got := WithLowBits(tt.byte, tt.k, tt.v)
+			assert.Equal(t, tt.want, got, "WithLowBits() = %v, want %v", got, tt.want)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package jpegdct
+
+import "math"
+
+// maxAC is the largest magnitude of an AC coefficient of a baseline JPEG with 8-bit samples.
+const maxAC = 1023
+
+// minAC is the smallest AC coefficient that FromImage produces. It is the smallest multiple of four above -maxAC,
+// so the two lowest bits of every coefficient can be changed without leaving the range of a baseline JPEG.
+const minAC = -maxAC + 3
+
+// luminanceQuant is the example luminance quantization table of the JPEG standard (Annex K) in natural order.
+var luminanceQuant = [BlockLen]uint16{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+// chrominanceQuant is the example chrominance quantization table of the JPEG standard (Annex K) in natural order.
+var chrominanceQuant = [BlockLen]uint16{
+	17, 18, 24, 47, 99, 99, 99, 99,
+	18, 21, 26, 66, 99, 99, 99, 99,
+	24, 26, 56, 99, 99, 99, 99, 99,
+	47, 66, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+}
+
+// scaleQuant scales the given quantization table to the given quality (1-100) like the IJG library does.
+func scaleQuant(table [BlockLen]uint16, quality int) [BlockLen]uint16 {
+	if quality < 1 {
+		quality = 1
+	} else if quality > 100 {
+		quality = 100
+	}
+
+	scale := 200 - 2*quality
+	if quality < 50 {
+		scale = 5000 / quality
+	}
+
+	var scaled [BlockLen]uint16
+	for i, q := range table {
+		v := (int(q)*scale + 50) / 100
+		if v < 1 {
+			v = 1
+		} else if v > 255 {
+			v = 255
+		}
+		scaled[i] = uint16(v)
+	}
+	return scaled
+}
+
+// cosTable holds the DCT basis functions: cosTable[u][x] = C(u)/2 * cos((2x+1)uπ/16)
+// with C(0) = 1/√2 and C(u) = 1 otherwise.
+var cosTable = func() [BlockSize][BlockSize]float64 {
+	var t [BlockSize][BlockSize]float64
+	for u := 0; u < BlockSize; u++ {
+		cu := 1.0
+		if u == 0 {
+			cu = 1 / math.Sqrt2
+		}
+		for x := 0; x < BlockSize; x++ {
+			t[u][x] = cu / 2 * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return t
+}()
+
+// fdct computes the two dimensional forward DCT of the given level shifted samples in natural order.
+func fdct(samples *[BlockLen]float64) [BlockLen]float64 {
+	var rows, coeffs [BlockLen]float64
+	for y := 0; y < BlockSize; y++ {
+		for u := 0; u < BlockSize; u++ {
+			var sum float64
+			for x := 0; x < BlockSize; x++ {
+				sum += cosTable[u][x] * samples[y*BlockSize+x]
+			}
+			rows[y*BlockSize+u] = sum
+		}
+	}
+	for v := 0; v < BlockSize; v++ {
+		for u := 0; u < BlockSize; u++ {
+			var sum float64
+			for y := 0; y < BlockSize; y++ {
+				sum += cosTable[v][y] * rows[y*BlockSize+u]
+			}
+			coeffs[v*BlockSize+u] = sum
+		}
+	}
+	return coeffs
+}
+
+// idct computes the two dimensional inverse DCT of the given dequantized coefficients in natural order.
+func idct(coeffs *[BlockLen]float64) [BlockLen]float64 {
+	var cols, samples [BlockLen]float64
+	for y := 0; y < BlockSize; y++ {
+		for u := 0; u < BlockSize; u++ {
+			var sum float64
+			for v := 0; v < BlockSize; v++ {
+				sum += cosTable[v][y] * coeffs[v*BlockSize+u]
+			}
+			cols[y*BlockSize+u] = sum
+		}
+	}
+	for y := 0; y < BlockSize; y++ {
+		for x := 0; x < BlockSize; x++ {
+			var sum float64
+			for u := 0; u < BlockSize; u++ {
+				sum += cosTable[u][x] * cols[y*BlockSize+u]
+			}
+			samples[y*BlockSize+x] = sum
+		}
+	}
+	return samples
+}
@@ -0,0 +1,132 @@
+package jpegdct
+
+import "errors"
+
+// huffmanSpec is a Huffman table as it is stored in a DHT segment: the number of codes of each
+// length from 1 to 16 bits followed by the symbols in order of increasing code length.
+type huffmanSpec struct {
+	counts  [16]byte
+	symbols []byte
+}
+
+// The example Huffman tables of the JPEG standard (Annex K). They can encode any baseline coefficients.
+var (
+	luminanceDC = huffmanSpec{
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	}
+	luminanceAC = huffmanSpec{
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	}
+	chrominanceDC = huffmanSpec{
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	}
+	chrominanceAC = huffmanSpec{
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	}
+)
+
+// huffmanCode is the code of a symbol. The code occupies the length lowest bits of bits.
+type huffmanCode struct {
+	bits   uint32
+	length uint
+}
+
+// encodingTable returns the codes of all symbols of the table (Annex C of the JPEG standard).
+func (s huffmanSpec) encodingTable() map[byte]huffmanCode {
+	table := map[byte]huffmanCode{}
+	code, k := uint32(0), 0
+	for i, n := range s.counts {
+		for j := 0; j < int(n); j++ {
+			table[s.symbols[k]] = huffmanCode{bits: code, length: uint(i + 1)}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return table
+}
+
+// huffmanDecoder decodes symbols of a Huffman table bit by bit (Annex F.2.2.3 of the JPEG standard).
+type huffmanDecoder struct {
+	symbols []byte
+
+	// For each code length: the largest code (-1 if there is none), the smallest code and
+	// the index of the symbol of the smallest code.
+	maxCode [17]int32
+	minCode [17]int32
+	valPtr  [17]int
+}
+
+func newHuffmanDecoder(s huffmanSpec) (*huffmanDecoder, error) {
+	total := 0
+	for _, n := range s.counts {
+		total += int(n)
+	}
+	if total == 0 || total > 256 || total != len(s.symbols) {
+		return nil, errors.New("invalid huffman table")
+	}
+
+	d := &huffmanDecoder{symbols: s.symbols}
+	code, k := int32(0), 0
+	for i, n := range s.counts {
+		length := i + 1
+		d.maxCode[length] = -1
+		if n > 0 {
+			d.valPtr[length] = k
+			d.minCode[length] = code
+			code += int32(n)
+			k += int(n)
+			d.maxCode[length] = code - 1
+		}
+		code <<= 1
+	}
+	return d, nil
+}
@@ -0,0 +1,248 @@
+// Package jpegdct reads and writes baseline JPEG images on the level of their quantized DCT coefficients.
+// Unlike the image/jpeg package it doesn't go through pixels, so coefficients that are written are
+// exactly the ones that are read back. This allows embedding data into the coefficients of a JPEG image.
+// Only images without chroma subsampling are supported.
+package jpegdct
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+)
+
+// BlockSize is the width and height of a DCT block in pixels.
+const BlockSize = 8
+
+// BlockLen is the number of coefficients of a DCT block.
+const BlockLen = BlockSize * BlockSize
+
+// Zigzag maps the position of a coefficient in zig-zag order to its index in natural (row by row) order.
+var Zigzag = [BlockLen]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// Image holds the quantized DCT coefficients of a JPEG image. It is laid out like an image.Gray16 whose
+// pixels are the 8x8 blocks of the image: the values of a "pixel" are the 64 coefficients of each component
+// in natural order, stored as big endian int16. Consequently, Rect is measured in blocks and not in pixels.
+type Image struct {
+	// Pix holds the coefficients of the blocks. The coefficients of the block at (x, y) start at
+	// Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*Components*BlockLen*2].
+	Pix []uint8
+
+	// Stride is the Pix stride (in bytes) between vertically adjacent blocks.
+	Stride int
+
+	// Rect is the image's bounds in blocks.
+	Rect image.Rectangle
+
+	// Components is the number of color components, 1 for grayscale and 3 for YCbCr images.
+	Components int
+
+	// Width and Height are the dimensions in pixels of the whole image (also for sub images).
+	// The blocks at the right and bottom edge may extend beyond them.
+	Width, Height int
+
+	// Quant holds the quantization table of each component in natural order.
+	Quant [][BlockLen]uint16
+}
+
+// NewImage returns a new Image of the given dimensions in pixels with all coefficients set to zero.
+// It uses the given quantization tables, one per component.
+func NewImage(width, height int, quant [][BlockLen]uint16) *Image {
+	components := len(quant)
+	blocksX := (width + BlockSize - 1) / BlockSize
+	blocksY := (height + BlockSize - 1) / BlockSize
+	stride := blocksX * components * BlockLen * 2
+	return &Image{
+		Pix:        make([]uint8, stride*blocksY),
+		Stride:     stride,
+		Rect:       image.Rect(0, 0, blocksX, blocksY),
+		Components: components,
+		Width:      width,
+		Height:     height,
+		Quant:      quant,
+	}
+}
+
+// ColorModel returns the color model of the At method.
+func (m *Image) ColorModel() color.Model { return color.GrayModel }
+
+// Bounds returns the bounds of the image in blocks.
+func (m *Image) Bounds() image.Rectangle { return m.Rect }
+
+// At returns the average luminance of the block at (x, y), which is derived from its DC coefficient.
+// Use Image to get the actual pixels.
+func (m *Image) At(x, y int) color.Color {
+	if !(image.Point{X: x, Y: y}.In(m.Rect)) {
+		return color.Gray{}
+	}
+	dc := float64(m.Coefficient(x, y, 0, 0)) * float64(m.Quant[0][0]) / BlockSize
+	return color.Gray{Y: clamp(dc + 128)}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to the block at (x, y).
+func (m *Image) PixOffset(x, y int) int {
+	return (y-m.Rect.Min.Y)*m.Stride + (x-m.Rect.Min.X)*m.Components*BlockLen*2
+}
+
+// SubImage returns an image representing the blocks of the image visible through r.
+// The returned value shares the coefficients with the original image.
+func (m *Image) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(m.Rect)
+	if r.Empty() {
+		return &Image{Components: m.Components, Width: m.Width, Height: m.Height, Quant: m.Quant}
+	}
+	i := m.PixOffset(r.Min.X, r.Min.Y)
+	return &Image{
+		Pix:        m.Pix[i:],
+		Stride:     m.Stride,
+		Rect:       r,
+		Components: m.Components,
+		Width:      m.Width,
+		Height:     m.Height,
+		Quant:      m.Quant,
+	}
+}
+
+// Coefficient returns the k-th coefficient (in natural order) of the given component of the block at (x, y).
+func (m *Image) Coefficient(x, y, component, k int) int16 {
+	i := m.PixOffset(x, y) + (component*BlockLen+k)*2
+	return int16(binary.BigEndian.Uint16(m.Pix[i:]))
+}
+
+// SetCoefficient sets the k-th coefficient (in natural order) of the given component of the block at (x, y).
+func (m *Image) SetCoefficient(x, y, component, k int, v int16) {
+	i := m.PixOffset(x, y) + (component*BlockLen+k)*2
+	binary.BigEndian.PutUint16(m.Pix[i:], uint16(v))
+}
+
+// PixelRect returns the pixels covered by the given rectangle of blocks.
+func (m *Image) PixelRect(r image.Rectangle) image.Rectangle {
+	return image.Rect(r.Min.X*BlockSize, r.Min.Y*BlockSize, r.Max.X*BlockSize, r.Max.Y*BlockSize).
+		Intersect(image.Rect(0, 0, m.Width, m.Height))
+}
+
+// FromImage transforms the given image into quantized DCT coefficients using the standard quantization
+// tables scaled to the given quality (1-100). Grayscale images result in a single component, all other
+// images are converted to YCbCr without chroma subsampling. Partial blocks at the edges are padded by
+// repeating the last row and column. The AC coefficients are limited to the range from -1020 to 1023, so their
+// two lowest bits can carry data.
+func FromImage(src image.Image, quality int) *Image {
+	bounds := src.Bounds()
+
+	var quant [][BlockLen]uint16
+	switch src.(type) {
+	case *image.Gray, *image.Gray16:
+		quant = [][BlockLen]uint16{scaleQuant(luminanceQuant, quality)}
+	default:
+		chroma := scaleQuant(chrominanceQuant, quality)
+		quant = [][BlockLen]uint16{scaleQuant(luminanceQuant, quality), chroma, chroma}
+	}
+
+	m := NewImage(bounds.Dx(), bounds.Dy(), quant)
+
+	samples := make([][BlockLen]float64, m.Components)
+	for by := 0; by < m.Rect.Dy(); by++ {
+		for bx := 0; bx < m.Rect.Dx(); bx++ {
+			for i := 0; i < BlockLen; i++ {
+				x := bounds.Min.X + minInt(bx*BlockSize+i%BlockSize, bounds.Dx()-1)
+				y := bounds.Min.Y + minInt(by*BlockSize+i/BlockSize, bounds.Dy()-1)
+
+				if m.Components == 1 {
+					samples[0][i] = float64(color.GrayModel.Convert(src.At(x, y)).(color.Gray).Y) - 128
+					continue
+				}
+
+				r, g, b, _ := src.At(x, y).RGBA()
+				rf, gf, bf := float64(r>>8), float64(g>>8), float64(b>>8)
+				samples[0][i] = 0.299*rf + 0.587*gf + 0.114*bf - 128
+				samples[1][i] = -0.168736*rf - 0.331264*gf + 0.5*bf
+				samples[2][i] = 0.5*rf - 0.418688*gf - 0.081312*bf
+			}
+
+			for c := range samples {
+				coeffs := fdct(&samples[c])
+				for k, v := range coeffs {
+					q := math.Round(v / float64(m.Quant[c][k]))
+					if k > 0 {
+						// Baseline JPEG allows AC magnitudes up to 1023, with room for the two lowest bits
+						q = math.Max(minAC, math.Min(maxAC, q))
+					}
+					m.SetCoefficient(bx, by, c, k, int16(q))
+				}
+			}
+		}
+	}
+
+	return m
+}
+
+// Image decodes the coefficients into pixels. It returns an *image.Gray for images with a single component
+// and an *image.YCbCr otherwise. The bounds of the returned image are the pixels covered by the blocks.
+func (m *Image) Image() image.Image {
+	r := m.PixelRect(m.Rect)
+
+	var gray *image.Gray
+	var ycbcr *image.YCbCr
+	if m.Components == 1 {
+		gray = image.NewGray(r)
+	} else {
+		ycbcr = image.NewYCbCr(r, image.YCbCrSubsampleRatio444)
+	}
+
+	var coeffs [BlockLen]float64
+	for by := m.Rect.Min.Y; by < m.Rect.Max.Y; by++ {
+		for bx := m.Rect.Min.X; bx < m.Rect.Max.X; bx++ {
+			for c := 0; c < m.Components; c++ {
+				for k := range coeffs {
+					coeffs[k] = float64(m.Coefficient(bx, by, c, k)) * float64(m.Quant[c][k])
+				}
+				samples := idct(&coeffs)
+
+				for i, s := range samples {
+					p := image.Pt(bx*BlockSize+i%BlockSize, by*BlockSize+i/BlockSize)
+					if !p.In(r) {
+						continue
+					}
+
+					v := clamp(s + 128)
+					switch {
+					case gray != nil:
+						gray.Pix[gray.PixOffset(p.X, p.Y)] = v
+					case c == 0:
+						ycbcr.Y[ycbcr.YOffset(p.X, p.Y)] = v
+					case c == 1:
+						ycbcr.Cb[ycbcr.COffset(p.X, p.Y)] = v
+					default:
+						ycbcr.Cr[ycbcr.COffset(p.X, p.Y)] = v
+					}
+				}
+			}
+		}
+	}
+
+	if gray != nil {
+		return gray
+	}
+	return ycbcr
+}
+
+// clamp rounds the given value and clamps it to the range of a byte.
+func clamp(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
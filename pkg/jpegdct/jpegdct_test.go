@@ -0,0 +1,152 @@
+package jpegdct
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testImage returns a colorful image whose dimensions are no multiple of the block size.
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 255 / w), G: uint8(y * 255 / h), B: uint8((x*y + 7*x) % 256), A: 255})
+		}
+	}
+	return img
+}
+
+func TestEncodeDecode_PreservesCoefficients(t *testing.T) {
+	m := FromImage(testImage(37, 21), 90)
+	assert.Equal(t, image.Rect(0, 0, 5, 3), m.Bounds())
+
+	// Set the lowest bit of some coefficients like an embedder would do
+	for k := 6; k < 28; k++ {
+		m.SetCoefficient(2, 1, 0, Zigzag[k], m.Coefficient(2, 1, 0, Zigzag[k])|1)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, Encode(&buf, m))
+
+	decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, m.Pix, decoded.Pix)
+	assert.Equal(t, m.Quant, decoded.Quant)
+	assert.Equal(t, 37, decoded.Width)
+	assert.Equal(t, 21, decoded.Height)
+}
+
+func TestFromImage_RoomForLowBits(t *testing.T) {
+	m := NewImage(8, 8, [][BlockLen]uint16{scaleQuant(luminanceQuant, 100)})
+	m.SetCoefficient(0, 0, 0, 1, minAC)
+	m.SetCoefficient(0, 0, 0, 2, maxAC)
+	assert.Equal(t, int16(-1020), m.Coefficient(0, 0, 0, 1))
+
+	// Clearing and setting the two lowest bits keeps the coefficients at the limits in range
+	for _, bits := range []int16{0, 3} {
+		m.SetCoefficient(0, 0, 0, 1, minAC&^3|bits)
+		m.SetCoefficient(0, 0, 0, 2, maxAC&^3|bits)
+		assert.NoError(t, Encode(ioutil.Discard, m), bits)
+	}
+	m.SetCoefficient(0, 0, 0, 1, -maxAC&^3)
+	assert.Error(t, Encode(ioutil.Discard, m))
+
+	// The AC coefficients of hard edges stay within the limits
+	src := image.NewGray(image.Rect(0, 0, 8, 8))
+	for i := range src.Pix {
+		if i%8 >= 4 {
+			src.Pix[i] = 255
+		}
+	}
+	m = FromImage(src, 100)
+	for k := 1; k < BlockLen; k++ {
+		v := m.Coefficient(0, 0, 0, k)
+		assert.True(t, v >= minAC && v <= maxAC, v)
+	}
+}
+
+func TestEncodeDecode_Gray(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 16, 9))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 3)
+	}
+
+	m := FromImage(src, 75)
+	assert.Equal(t, 1, m.Components)
+
+	var buf bytes.Buffer
+	require.NoError(t, Encode(&buf, m))
+
+	decoded, err := Decode(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, m.Pix, decoded.Pix)
+}
+
+func TestEncode_ReadableByImageJPEG(t *testing.T) {
+	src := testImage(37, 21)
+	m := FromImage(src, 95)
+
+	var buf bytes.Buffer
+	require.NoError(t, Encode(&buf, m))
+
+	decoded, err := jpeg.Decode(&buf)
+	require.NoError(t, err)
+	require.Equal(t, src.Bounds(), decoded.Bounds())
+
+	// The decoded pixels are close to the original ones and to the ones of Image
+	rendered := m.Image()
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 37; x++ {
+			r1, g1, b1, _ := src.At(x, y).RGBA()
+			r2, g2, b2, _ := decoded.At(x, y).RGBA()
+			r3, g3, b3, _ := rendered.At(x, y).RGBA()
+			for _, d := range []int{int(r1>>8) - int(r2>>8), int(g1>>8) - int(g2>>8), int(b1>>8) - int(b2>>8)} {
+				assert.InDelta(t, 0, d, 24)
+			}
+			for _, d := range []int{int(r3>>8) - int(r2>>8), int(g3>>8) - int(g2>>8), int(b3>>8) - int(b2>>8)} {
+				assert.InDelta(t, 0, d, 4)
+			}
+		}
+	}
+}
+
+func TestDecode_ImageJPEGGray(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 20, 12))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i)
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, src, &jpeg.Options{Quality: 80}))
+
+	m, err := Decode(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, 1, m.Components)
+	assert.Equal(t, image.Rect(0, 0, 3, 2), m.Bounds())
+}
+
+func TestDecode_ChromaSubsamplingUnsupported(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, testImage(16, 16), nil))
+
+	_, err := Decode(&buf)
+	assert.IsType(t, UnsupportedError(""), err)
+}
+
+func TestImage_SubImage(t *testing.T) {
+	m := FromImage(testImage(37, 21), 90)
+	sub := m.SubImage(image.Rect(4, 2, 5, 3)).(*Image)
+
+	assert.Equal(t, m.Coefficient(4, 2, 1, 9), sub.Coefficient(4, 2, 1, 9))
+	assert.Equal(t, image.Rect(32, 16, 37, 21), sub.PixelRect(sub.Bounds()))
+
+	sub.SetCoefficient(4, 2, 0, 3, 42)
+	assert.EqualValues(t, 42, m.Coefficient(4, 2, 0, 3))
+}
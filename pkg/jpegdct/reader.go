@@ -0,0 +1,405 @@
+package jpegdct
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// FormatError reports that the input is not a valid JPEG image.
+type FormatError string
+
+func (e FormatError) Error() string { return "invalid JPEG format: " + string(e) }
+
+// UnsupportedError reports that the input uses a valid but unimplemented JPEG feature.
+type UnsupportedError string
+
+func (e UnsupportedError) Error() string { return "unsupported JPEG feature: " + string(e) }
+
+// Decode reads a sequential (baseline or extended) Huffman coded JPEG image from r and returns its
+// quantized DCT coefficients. Progressive and arithmetic coded images and images with chroma
+// subsampling are not supported.
+func Decode(r io.Reader) (*Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 2 || data[0] != 0xff || data[1] != markerSOI {
+		return nil, FormatError("missing SOI marker")
+	}
+
+	d := &decoder{data: data, pos: 2}
+	for {
+		marker, err := d.readMarker()
+		if err != nil {
+			return nil, err
+		}
+
+		if marker == markerEOI {
+			break
+		}
+
+		// All other markers start a segment with a length field
+		if d.pos+2 > len(d.data) {
+			return nil, FormatError("short segment length")
+		}
+		n := int(d.data[d.pos])<<8 | int(d.data[d.pos+1]) - 2
+		d.pos += 2
+		if n < 0 || d.pos+n > len(d.data) {
+			return nil, FormatError("short segment")
+		}
+		segment := d.data[d.pos : d.pos+n]
+		d.pos += n
+
+		switch {
+		case marker == markerSOF0 || marker == markerSOF1:
+			err = d.parseSOF(segment)
+		case marker == markerDHT:
+			err = d.parseDHT(segment)
+		case marker == markerDQT:
+			err = d.parseDQT(segment)
+		case marker == markerDRI:
+			if len(segment) != 2 {
+				return nil, FormatError("DRI has wrong length")
+			}
+			d.restartInterval = int(segment[0])<<8 | int(segment[1])
+		case marker == markerSOS:
+			err = d.decodeScan(segment)
+		case marker >= 0xc2 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc:
+			return nil, UnsupportedError("progressive, lossless or arithmetic coding")
+		}
+		// Everything else (application segments, comments) is skipped
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if d.img == nil {
+		return nil, FormatError("missing image data")
+	}
+	return d.img, nil
+}
+
+// component is a color component as declared in the frame header.
+type component struct {
+	id    byte
+	h, v  int
+	table int
+}
+
+// decoder holds the state while decoding a JPEG image.
+type decoder struct {
+	data []byte
+	pos  int
+
+	comps           []component
+	quant           [4][BlockLen]uint16
+	huff            [2][4]*huffmanDecoder
+	restartInterval int
+	img             *Image
+
+	// The bits of the entropy coded data that were read but not consumed yet.
+	bits  uint32
+	nBits uint
+}
+
+// readMarker returns the next marker. Fill bytes (0xff) in front of it are skipped.
+func (d *decoder) readMarker() (byte, error) {
+	if d.pos >= len(d.data) || d.data[d.pos] != 0xff {
+		return 0, FormatError("missing marker")
+	}
+	for d.pos < len(d.data) && d.data[d.pos] == 0xff {
+		d.pos++
+	}
+	if d.pos >= len(d.data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	marker := d.data[d.pos]
+	d.pos++
+	return marker, nil
+}
+
+func (d *decoder) parseSOF(segment []byte) error {
+	if d.img != nil {
+		return FormatError("multiple frames")
+	}
+	if len(segment) < 6 {
+		return FormatError("SOF has wrong length")
+	}
+	if segment[0] != 8 {
+		return UnsupportedError("sample precision other than 8 bits")
+	}
+
+	height := int(segment[1])<<8 | int(segment[2])
+	width := int(segment[3])<<8 | int(segment[4])
+	n := int(segment[5])
+	if width == 0 || height == 0 {
+		return UnsupportedError("image with unknown height")
+	}
+	if n != 1 && n != 3 {
+		return UnsupportedError(fmt.Sprintf("%d components", n))
+	}
+	if len(segment) != 6+3*n {
+		return FormatError("SOF has wrong length")
+	}
+
+	d.comps = make([]component, n)
+	for i := range d.comps {
+		c := segment[6+3*i:]
+		d.comps[i] = component{id: c[0], h: int(c[1] >> 4), v: int(c[1] & 0x0f), table: int(c[2])}
+		if d.comps[i].table > 3 {
+			return FormatError("invalid quantization table")
+		}
+		// The sampling factors of a single component don't matter
+		if n > 1 && (d.comps[i].h != 1 || d.comps[i].v != 1) {
+			return UnsupportedError("chroma subsampling")
+		}
+	}
+
+	// The quantization tables may be redefined until the scan, they are filled in by decodeScan
+	d.img = NewImage(width, height, make([][BlockLen]uint16, n))
+	return nil
+}
+
+func (d *decoder) parseDQT(segment []byte) error {
+	for len(segment) > 0 {
+		precision, id := segment[0]>>4, segment[0]&0x0f
+		if id > 3 || precision > 1 {
+			return FormatError("invalid DQT")
+		}
+
+		size := BlockLen * int(precision+1)
+		if len(segment) < 1+size {
+			return FormatError("DQT has wrong length")
+		}
+
+		for i, k := range Zigzag {
+			if precision == 1 {
+				d.quant[id][k] = uint16(segment[1+2*i])<<8 | uint16(segment[2+2*i])
+			} else {
+				d.quant[id][k] = uint16(segment[1+i])
+			}
+		}
+		segment = segment[1+size:]
+	}
+	return nil
+}
+
+func (d *decoder) parseDHT(segment []byte) error {
+	for len(segment) > 0 {
+		if len(segment) < 17 {
+			return FormatError("DHT has wrong length")
+		}
+		class, id := segment[0]>>4, segment[0]&0x0f
+		if class > 1 || id > 3 {
+			return FormatError("invalid DHT")
+		}
+
+		var spec huffmanSpec
+		copy(spec.counts[:], segment[1:17])
+		total := 0
+		for _, n := range spec.counts {
+			total += int(n)
+		}
+		if len(segment) < 17+total {
+			return FormatError("DHT has wrong length")
+		}
+		spec.symbols = segment[17 : 17+total]
+
+		decoder, err := newHuffmanDecoder(spec)
+		if err != nil {
+			return err
+		}
+		d.huff[class][id] = decoder
+		segment = segment[17+total:]
+	}
+	return nil
+}
+
+// decodeScan decodes the entropy coded data of the scan with the given header. Interleaved scans contain
+// one block per component and MCU, a scan of a single component contains its blocks row by row.
+func (d *decoder) decodeScan(header []byte) error {
+	if d.img == nil {
+		return FormatError("missing SOF marker")
+	}
+	if len(header) < 1 || len(header) != 4+2*int(header[0]) {
+		return FormatError("SOS has wrong length")
+	}
+
+	type scanComponent struct {
+		index  int
+		dc, ac *huffmanDecoder
+	}
+
+	scan := make([]scanComponent, header[0])
+	for i := range scan {
+		id, tables := header[1+2*i], header[2+2*i]
+		scan[i].index = -1
+		for j, c := range d.comps {
+			if c.id == id {
+				scan[i].index = j
+			}
+		}
+		if scan[i].index < 0 || tables>>4 > 3 || tables&0x0f > 3 {
+			return FormatError("invalid SOS component")
+		}
+		scan[i].dc, scan[i].ac = d.huff[0][tables>>4], d.huff[1][tables&0x0f]
+		if scan[i].dc == nil || scan[i].ac == nil {
+			return FormatError("missing huffman table")
+		}
+		d.img.Quant[scan[i].index] = d.quant[d.comps[scan[i].index].table]
+	}
+
+	blocks := d.img.Rect.Dx() * d.img.Rect.Dy()
+	preds := make([]int, len(scan))
+	d.bits, d.nBits = 0, 0
+	for mcu := 0; mcu < blocks; mcu++ {
+		if d.restartInterval > 0 && mcu > 0 && mcu%d.restartInterval == 0 {
+			if err := d.readRestart(); err != nil {
+				return err
+			}
+			for i := range preds {
+				preds[i] = 0
+			}
+		}
+
+		x, y := mcu%d.img.Rect.Dx(), mcu/d.img.Rect.Dx()
+		for i, sc := range scan {
+			if err := d.decodeBlock(x, y, sc.index, &preds[i], sc.dc, sc.ac); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// decodeBlock decodes the block of the given component at (x, y). pred is the DC coefficient
+// of the previous block of the component.
+func (d *decoder) decodeBlock(x, y, c int, pred *int, dc, ac *huffmanDecoder) error {
+	size, err := d.decodeHuffman(dc)
+	if err != nil {
+		return err
+	}
+	if size > 11 {
+		return FormatError("bad DC size")
+	}
+	diff, err := d.receiveExtend(uint(size))
+	if err != nil {
+		return err
+	}
+	*pred += diff
+	d.img.SetCoefficient(x, y, c, 0, int16(*pred))
+
+	for k := 1; k < BlockLen; k++ {
+		rs, err := d.decodeHuffman(ac)
+		if err != nil {
+			return err
+		}
+
+		run, size := int(rs>>4), uint(rs&0x0f)
+		if size == 0 {
+			if run != 15 {
+				// End of block
+				break
+			}
+			k += 15
+			continue
+		}
+
+		k += run
+		if k >= BlockLen {
+			return FormatError("bad RLE")
+		}
+		v, err := d.receiveExtend(size)
+		if err != nil {
+			return err
+		}
+		d.img.SetCoefficient(x, y, c, Zigzag[k], int16(v))
+	}
+
+	return nil
+}
+
+// readByte returns the next byte of the entropy coded data with stuffed bytes removed. If a marker is
+// reached, zeros are returned without consuming the marker.
+func (d *decoder) readByte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	b := d.data[d.pos]
+	if b != 0xff {
+		d.pos++
+		return b, nil
+	}
+
+	if d.pos+1 >= len(d.data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if d.data[d.pos+1] == 0 {
+		d.pos += 2
+		return 0xff, nil
+	}
+	return 0, nil
+}
+
+// readBit returns the next bit of the entropy coded data.
+func (d *decoder) readBit() (uint32, error) {
+	if d.nBits == 0 {
+		b, err := d.readByte()
+		if err != nil {
+			return 0, err
+		}
+		d.bits, d.nBits = uint32(b), 8
+	}
+	d.nBits--
+	return d.bits >> d.nBits & 1, nil
+}
+
+// decodeHuffman decodes the next symbol with the given Huffman table.
+func (d *decoder) decodeHuffman(h *huffmanDecoder) (byte, error) {
+	code := int32(0)
+	for length := 1; length <= 16; length++ {
+		b, err := d.readBit()
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | int32(b)
+		if code <= h.maxCode[length] {
+			return h.symbols[h.valPtr[length]+int(code-h.minCode[length])], nil
+		}
+	}
+	return 0, FormatError("bad huffman code")
+}
+
+// receiveExtend reads a value of the given size in bits and converts it to a signed integer
+// (Annex F.2.2.1 of the JPEG standard).
+func (d *decoder) receiveExtend(size uint) (int, error) {
+	v := 0
+	for i := uint(0); i < size; i++ {
+		b, err := d.readBit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | int(b)
+	}
+	if size > 0 && v < 1<<(size-1) {
+		v += -1<<size + 1
+	}
+	return v, nil
+}
+
+// readRestart discards the remaining bits of the current byte and consumes the expected restart marker.
+func (d *decoder) readRestart() error {
+	d.bits, d.nBits = 0, 0
+	marker, err := d.readMarker()
+	if err != nil {
+		return err
+	}
+	if marker < markerRST0 || marker > markerRST7 {
+		return FormatError("missing restart marker")
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package jpegdct
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Markers of the JPEG file format.
+const (
+	markerSOI  = 0xd8 // start of image
+	markerEOI  = 0xd9 // end of image
+	markerSOF0 = 0xc0 // start of frame, baseline DCT
+	markerSOF1 = 0xc1 // start of frame, extended sequential DCT
+	markerDHT  = 0xc4 // define Huffman tables
+	markerDQT  = 0xdb // define quantization tables
+	markerDRI  = 0xdd // define restart interval
+	markerSOS  = 0xda // start of scan
+	markerRST0 = 0xd0 // first restart marker
+	markerRST7 = 0xd7 // last restart marker
+	markerAPP0 = 0xe0 // first application segment
+)
+
+// Encode writes the coefficients of the given image as a baseline JPEG image to w. The coefficients are
+// entropy coded without any loss, so decoding the result with Decode yields exactly the same coefficients.
+// The first component uses the luminance and all other components the chrominance Huffman tables of the
+// JPEG standard.
+func Encode(w io.Writer, m *Image) error {
+	if m.Components != 1 && m.Components != 3 {
+		return fmt.Errorf("unsupported number of components %d", m.Components)
+	}
+	if len(m.Quant) != m.Components {
+		return errors.New("missing quantization tables")
+	}
+
+	size := m.PixelRect(m.Rect).Size()
+	if size.X == 0 || size.Y == 0 || size.X > 0xffff || size.Y > 0xffff {
+		return fmt.Errorf("invalid image dimensions %dx%d", size.X, size.Y)
+	}
+
+	// Components with equal tables share them
+	var tables [][BlockLen]uint16
+	tableOf := make([]int, m.Components)
+	for c, q := range m.Quant {
+		tableOf[c] = len(tables)
+		for i, t := range tables {
+			if t == q {
+				tableOf[c] = i
+			}
+		}
+		if tableOf[c] == len(tables) {
+			tables = append(tables, q)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	e := &encoder{w: bw}
+
+	e.writeMarker(markerSOI)
+
+	// JFIF APP0 segment: version 1.01, no density, no thumbnail
+	e.writeSegment(markerAPP0, []byte{'J', 'F', 'I', 'F', 0, 1, 1, 0, 0, 1, 0, 1, 0, 0})
+
+	for i, t := range tables {
+		precision := byte(0)
+		for _, q := range t {
+			if q > 0xff {
+				precision = 1
+			}
+		}
+
+		data := []byte{precision<<4 | byte(i)}
+		for _, k := range Zigzag {
+			if precision == 1 {
+				data = append(data, byte(t[k]>>8))
+			}
+			data = append(data, byte(t[k]))
+		}
+		e.writeSegment(markerDQT, data)
+	}
+
+	sof := []byte{8, byte(size.Y >> 8), byte(size.Y), byte(size.X >> 8), byte(size.X), byte(m.Components)}
+	for c := 0; c < m.Components; c++ {
+		sof = append(sof, byte(c+1), 0x11, byte(tableOf[c]))
+	}
+	e.writeSegment(markerSOF0, sof)
+
+	specs := []huffmanSpec{luminanceDC, luminanceAC}
+	if m.Components > 1 {
+		specs = append(specs, chrominanceDC, chrominanceAC)
+	}
+	dht := []byte{}
+	for i, s := range specs {
+		class, id := byte(i%2), byte(i/2)
+		dht = append(dht, class<<4|id)
+		dht = append(dht, s.counts[:]...)
+		dht = append(dht, s.symbols...)
+	}
+	e.writeSegment(markerDHT, dht)
+
+	sos := []byte{byte(m.Components)}
+	for c := 0; c < m.Components; c++ {
+		table := byte(0)
+		if c > 0 {
+			table = 1
+		}
+		sos = append(sos, byte(c+1), table<<4|table)
+	}
+	sos = append(sos, 0, 63, 0)
+	e.writeSegment(markerSOS, sos)
+
+	dcTables := []map[byte]huffmanCode{luminanceDC.encodingTable(), chrominanceDC.encodingTable()}
+	acTables := []map[byte]huffmanCode{luminanceAC.encodingTable(), chrominanceAC.encodingTable()}
+
+	// All components have a sampling factor of one, so an MCU consists of one block of each component
+	preds := make([]int, m.Components)
+	for y := m.Rect.Min.Y; y < m.Rect.Max.Y; y++ {
+		for x := m.Rect.Min.X; x < m.Rect.Max.X; x++ {
+			for c := 0; c < m.Components; c++ {
+				table := 0
+				if c > 0 {
+					table = 1
+				}
+				if err := e.writeBlock(m, x, y, c, &preds[c], dcTables[table], acTables[table]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	e.flushBits()
+	e.writeMarker(markerEOI)
+
+	if e.err != nil {
+		return e.err
+	}
+	return bw.Flush()
+}
+
+// encoder writes the segments and the entropy coded data of a JPEG image. The first write error
+// is recorded and all subsequent writes are skipped.
+type encoder struct {
+	w   *bufio.Writer
+	err error
+
+	// The pending bits of the entropy coded data that don't form a complete byte yet.
+	bits  uint32
+	nBits uint
+}
+
+func (e *encoder) write(p []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+func (e *encoder) writeByte(b byte) {
+	if e.err == nil {
+		e.err = e.w.WriteByte(b)
+	}
+}
+
+func (e *encoder) writeMarker(marker byte) {
+	e.write([]byte{0xff, marker})
+}
+
+// writeSegment writes a marker segment with the given data. The length field is derived from the data.
+func (e *encoder) writeSegment(marker byte, data []byte) {
+	n := len(data) + 2
+	e.write([]byte{0xff, marker, byte(n >> 8), byte(n)})
+	e.write(data)
+}
+
+// writeBits appends the n lowest bits of bits to the entropy coded data. A 0xff byte is followed
+// by a stuffed 0x00 byte so that it isn't mistaken for a marker.
+func (e *encoder) writeBits(bits uint32, n uint) {
+	e.bits = e.bits<<n | bits&(1<<n-1)
+	e.nBits += n
+	for e.nBits >= 8 {
+		b := byte(e.bits >> (e.nBits - 8))
+		e.writeByte(b)
+		if b == 0xff {
+			e.writeByte(0)
+		}
+		e.nBits -= 8
+	}
+}
+
+// flushBits pads the entropy coded data with 1-bits to the next byte boundary.
+func (e *encoder) flushBits() {
+	if e.nBits > 0 {
+		e.writeBits(1<<(8-e.nBits)-1, 8-e.nBits)
+	}
+}
+
+// writeValue writes the Huffman code of the given symbol followed by the size lowest bits of the
+// value (in one's complement for negative values).
+func (e *encoder) writeValue(table map[byte]huffmanCode, symbol byte, size uint, value int) error {
+	code, ok := table[symbol]
+	if !ok {
+		return fmt.Errorf("no huffman code for symbol %#02x", symbol)
+	}
+	e.writeBits(code.bits, code.length)
+
+	if value < 0 {
+		value--
+	}
+	e.writeBits(uint32(value), size)
+	return nil
+}
+
+// writeBlock entropy codes the block of the given component at (x, y). pred is the DC coefficient
+// of the previous block of the component.
+func (e *encoder) writeBlock(m *Image, x, y, c int, pred *int, dc, ac map[byte]huffmanCode) error {
+	v := int(m.Coefficient(x, y, c, 0))
+	diff := v - *pred
+	*pred = v
+
+	size := bitSize(diff)
+	if size > 11 {
+		return fmt.Errorf("dc coefficient %d out of range", v)
+	}
+	if err := e.writeValue(dc, byte(size), size, diff); err != nil {
+		return err
+	}
+
+	run := 0
+	for k := 1; k < BlockLen; k++ {
+		v := int(m.Coefficient(x, y, c, Zigzag[k]))
+		if v == 0 {
+			run++
+			continue
+		}
+
+		// Runs of 16 zeros
+		for ; run > 15; run -= 16 {
+			if err := e.writeValue(ac, 0xf0, 0, 0); err != nil {
+				return err
+			}
+		}
+
+		size := bitSize(v)
+		if size > 10 {
+			return fmt.Errorf("ac coefficient %d out of range", v)
+		}
+		if err := e.writeValue(ac, byte(run<<4)|byte(size), size, v); err != nil {
+			return err
+		}
+		run = 0
+	}
+
+	// End of block
+	if run > 0 {
+		return e.writeValue(ac, 0x00, 0, 0)
+	}
+	return nil
+}
+
+// bitSize returns the number of bits needed to represent the magnitude of v.
+func bitSize(v int) uint {
+	if v < 0 {
+		v = -v
+	}
+	n := uint(0)
+	for ; v > 0; v >>= 1 {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,222 @@
+// Package merkle implements the binary Merkle hash tree of RFC 6962 (section 2.1). Leaf and node hashes are
+// domain separated by a prefix byte, so a leaf can never be passed off as an inner node or vice versa.
+//
+// The tree of n > 1 leaves is split at the largest power of two k smaller than n: the left subtree holds
+// the first k leaves, the right subtree the remaining n-k leaves. So for an odd number of leaves nothing
+// is duplicated, the last leaf (or subtree) is instead combined with a larger subtree on a higher level.
+// Proofs are generated by the index of a leaf and verified by the index and the size of the tree.
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// The prefixes of the hashed data of leaves and inner nodes.
+const (
+	LeafPrefix byte = 0x00
+	NodePrefix byte = 0x01
+)
+
+// Hasher computes the leaf and node hashes of a tree.
+type Hasher struct {
+	// New creates the underlying hash function.
+	New func() hash.Hash
+
+	// Truncate truncates the hashes of the children of a node to the given number of bytes before they are
+	// combined. Proofs then only need to contain the truncated hashes while the root keeps the full digest
+	// length. Zero combines the full hashes.
+	Truncate int
+}
+
+// NewLeaf returns a hash to which the data of a leaf can be written. The leaf prefix is already written.
+func (h Hasher) NewLeaf() hash.Hash {
+	hsh := h.New()
+	hsh.Write([]byte{LeafPrefix})
+	return hsh
+}
+
+// HashLeaf returns the hash of a leaf with the given data: H(0x00 || data).
+func (h Hasher) HashLeaf(data []byte) []byte {
+	hsh := h.NewLeaf()
+	hsh.Write(data)
+	return hsh.Sum(nil)
+}
+
+// HashChildren returns the hash of an inner node with the given children: H(0x01 || left || right),
+// where the hashes of the children are truncated first.
+func (h Hasher) HashChildren(left, right []byte) []byte {
+	hsh := h.New()
+	hsh.Write([]byte{NodePrefix})
+	hsh.Write(h.truncate(left))
+	hsh.Write(h.truncate(right))
+	return hsh.Sum(nil)
+}
+
+// truncate returns the first Truncate bytes of the given hash.
+func (h Hasher) truncate(b []byte) []byte {
+	if h.Truncate > 0 && len(b) > h.Truncate {
+		return b[:h.Truncate]
+	}
+	return b
+}
+
+// Tree is a Merkle tree over a list of leaf hashes.
+type Tree struct {
+	hasher Hasher
+	leaves [][]byte
+	root   []byte
+
+	// The hashes of all subtrees [start, end) of more than one leaf.
+	nodes map[[2]int][]byte
+}
+
+// New builds the tree over the given leaf hashes (see Hasher.HashLeaf). At least one leaf is required.
+func New(hasher Hasher, leaves [][]byte) (*Tree, error) {
+	if len(leaves) == 0 {
+		return nil, errors.New("merkle: tree without leaves")
+	}
+
+	t := &Tree{hasher: hasher, leaves: leaves, nodes: map[[2]int][]byte{}}
+	t.root = t.subtreeHash(0, len(leaves))
+	return t, nil
+}
+
+// Root returns the root hash of the tree. A tree of a single leaf has the leaf hash as its root.
+func (t *Tree) Root() []byte {
+	return t.root
+}
+
+// Size returns the number of leaves.
+func (t *Tree) Size() int {
+	return len(t.leaves)
+}
+
+// Proof returns the audit path of the leaf with the given index: the hashes of the siblings of all nodes
+// on the way from the leaf to the root, starting at the bottom. The hashes are truncated like the children
+// of a node (see Hasher.Truncate).
+func (t *Tree) Proof(index int) ([][]byte, error) {
+	if index < 0 || index >= len(t.leaves) {
+		return nil, fmt.Errorf("merkle: leaf index %d out of range [0, %d)", index, len(t.leaves))
+	}
+
+	var siblings [][]byte
+	start, end := 0, len(t.leaves)
+	for end-start > 1 {
+		k := splitPoint(end - start)
+		if index < start+k {
+			siblings = append(siblings, t.subtreeHash(start+k, end))
+			end = start + k
+		} else {
+			siblings = append(siblings, t.subtreeHash(start, start+k))
+			start += k
+		}
+	}
+
+	// The siblings were collected from the top to the bottom
+	proof := make([][]byte, len(siblings))
+	for i, sibling := range siblings {
+		proof[len(siblings)-1-i] = t.hasher.truncate(sibling)
+	}
+	return proof, nil
+}
+
+// subtreeHash returns the hash of the subtree of the leaves [start, end).
+func (t *Tree) subtreeHash(start, end int) []byte {
+	if end-start == 1 {
+		return t.leaves[start]
+	}
+	if h, ok := t.nodes[[2]int{start, end}]; ok {
+		return h
+	}
+
+	k := splitPoint(end - start)
+	h := t.hasher.HashChildren(t.subtreeHash(start, start+k), t.subtreeHash(start+k, end))
+	t.nodes[[2]int{start, end}] = h
+	return h
+}
+
+// ProofSides returns for each hash of the audit path of the leaf with the given index in a tree of the given
+// size (see Tree.Proof) whether the sibling is the right child of its parent. The length of the result is
+// the length of the audit path.
+func ProofSides(index, size int) ([]bool, error) {
+	if index < 0 || index >= size {
+		return nil, fmt.Errorf("merkle: leaf index %d out of range [0, %d)", index, size)
+	}
+
+	var sides []bool
+	start, end := 0, size
+	for end-start > 1 {
+		k := splitPoint(end - start)
+		right := index < start+k
+		if right {
+			end = start + k
+		} else {
+			start += k
+		}
+		sides = append([]bool{right}, sides...)
+	}
+	return sides, nil
+}
+
+// ProofRanges returns for each hash of the audit path of the leaf with the given index in a tree of the
+// given size (see Tree.Proof) the range [start, end) of the leaves of the subtree it is the hash of.
+func ProofRanges(index, size int) ([][2]int, error) {
+	if index < 0 || index >= size {
+		return nil, fmt.Errorf("merkle: leaf index %d out of range [0, %d)", index, size)
+	}
+
+	var ranges [][2]int
+	start, end := 0, size
+	for end-start > 1 {
+		k := splitPoint(end - start)
+		if index < start+k {
+			ranges = append([][2]int{{start + k, end}}, ranges...)
+			end = start + k
+		} else {
+			ranges = append([][2]int{{start, start + k}}, ranges...)
+			start += k
+		}
+	}
+	return ranges, nil
+}
+
+// RootFromProof returns the root hash that results from the given leaf hash and its audit path if the leaf
+// has the given index in a tree of the given size. It returns an error if the length of the audit path
+// doesn't match the position of the leaf.
+func RootFromProof(hasher Hasher, index, size int, leaf []byte, proof [][]byte) ([]byte, error) {
+	sides, err := ProofSides(index, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(sides) != len(proof) {
+		return nil, fmt.Errorf("merkle: audit path of leaf %d has %d hashes instead of %d", index, len(proof), len(sides))
+	}
+
+	h := leaf
+	for i, sibling := range proof {
+		if sides[i] {
+			h = hasher.HashChildren(h, sibling)
+		} else {
+			h = hasher.HashChildren(sibling, h)
+		}
+	}
+	return h, nil
+}
+
+// VerifyProof returns true if the given leaf hash and its audit path result in the given root hash.
+func VerifyProof(hasher Hasher, index, size int, leaf []byte, proof [][]byte, root []byte) bool {
+	calculated, err := RootFromProof(hasher, index, size, leaf, proof)
+	return err == nil && bytes.Equal(calculated, root)
+}
+
+// splitPoint returns the largest power of two smaller than n (n > 1).
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
@@ -0,0 +1,163 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var sha256Hasher = Hasher{New: sha256.New}
+
+// The leaves and the roots of the trees of the first n leaves of the certificate transparency test vectors.
+var (
+	testLeaves = []string{
+		"",
+		"00",
+		"10",
+		"2021",
+		"3031",
+		"40414243",
+		"5051525354555657",
+		"606162636465666768696a6b6c6d6e6f",
+	}
+	testRoots = []string{
+		"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+		"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+		"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+		"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+		"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+		"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+		"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+		"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+	}
+)
+
+func testLeafHashes(t *testing.T, hasher Hasher, n int) [][]byte {
+	leaves := make([][]byte, n)
+	for i := range leaves {
+		data, err := hex.DecodeString(testLeaves[i%len(testLeaves)])
+		require.NoError(t, err)
+		leaves[i] = hasher.HashLeaf(append(data, byte(i/len(testLeaves))))
+		if i < len(testLeaves) {
+			leaves[i] = hasher.HashLeaf(data)
+		}
+	}
+	return leaves
+}
+
+func TestTree_Root(t *testing.T) {
+	for n := 1; n <= len(testLeaves); n++ {
+		tree, err := New(sha256Hasher, testLeafHashes(t, sha256Hasher, n))
+		require.NoError(t, err)
+		assert.Equal(t, testRoots[n-1], hex.EncodeToString(tree.Root()), "tree of %d leaves", n)
+	}
+}
+
+func TestNew_NoLeaves(t *testing.T) {
+	_, err := New(sha256Hasher, nil)
+	assert.Error(t, err)
+}
+
+func TestTree_ProofRoundTrip(t *testing.T) {
+	for _, hasher := range []Hasher{sha256Hasher, {New: sha256.New, Truncate: 8}} {
+		for n := 1; n <= 33; n++ {
+			t.Run(fmt.Sprintf("truncate %d, %d leaves", hasher.Truncate, n), func(t *testing.T) {
+				leaves := testLeafHashes(t, hasher, n)
+				tree, err := New(hasher, leaves)
+				require.NoError(t, err)
+				assert.Equal(t, n, tree.Size())
+
+				for i := range leaves {
+					proof, err := tree.Proof(i)
+					require.NoError(t, err)
+
+					sides, err := ProofSides(i, n)
+					require.NoError(t, err)
+					assert.Len(t, sides, len(proof))
+
+					for _, h := range proof {
+						if hasher.Truncate > 0 {
+							assert.Len(t, h, hasher.Truncate)
+						}
+					}
+
+					assert.True(t, VerifyProof(hasher, i, n, leaves[i], proof, tree.Root()))
+
+					if n == 1 {
+						continue
+					}
+
+					// The proof doesn't work for another leaf or with a modified hash
+					assert.False(t, VerifyProof(hasher, i, n, leaves[(i+1)%n], proof, tree.Root()))
+
+					proof[0] = append([]byte{proof[0][0] ^ 1}, proof[0][1:]...)
+					assert.False(t, VerifyProof(hasher, i, n, leaves[i], proof, tree.Root()))
+				}
+			})
+		}
+	}
+}
+
+func TestProofSides(t *testing.T) {
+	// A tree of 5 leaves: ((0, 1), (2, 3)), 4
+	sides, err := ProofSides(0, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, true, true}, sides)
+
+	sides, err = ProofSides(3, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false, false, true}, sides)
+
+	// The last leaf is not duplicated but combined with the subtree of the first four leaves
+	sides, err = ProofSides(4, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{false}, sides)
+
+	sides, err = ProofSides(0, 1)
+	require.NoError(t, err)
+	assert.Empty(t, sides)
+
+	_, err = ProofSides(5, 5)
+	assert.Error(t, err)
+}
+
+func TestProofRanges(t *testing.T) {
+	// A tree of 5 leaves: ((0, 1), (2, 3)), 4
+	ranges, err := ProofRanges(3, 5)
+	require.NoError(t, err)
+	assert.Equal(t, [][2]int{{2, 3}, {0, 2}, {4, 5}}, ranges)
+
+	ranges, err = ProofRanges(4, 5)
+	require.NoError(t, err)
+	assert.Equal(t, [][2]int{{0, 4}}, ranges)
+
+	_, err = ProofRanges(-1, 5)
+	assert.Error(t, err)
+}
+
+func TestRootFromProof_WrongLength(t *testing.T) {
+	leaves := testLeafHashes(t, sha256Hasher, 5)
+	tree, err := New(sha256Hasher, leaves)
+	require.NoError(t, err)
+
+	proof, err := tree.Proof(0)
+	require.NoError(t, err)
+
+	_, err = RootFromProof(sha256Hasher, 0, 5, leaves[0], proof[:2])
+	assert.Error(t, err)
+}
+
+func TestHasher_DomainSeparation(t *testing.T) {
+	left, right := sha256Hasher.HashLeaf([]byte("a")), sha256Hasher.HashLeaf([]byte("b"))
+
+	// An inner node can't be passed off as a leaf with the concatenated children as data
+	node := sha256Hasher.HashChildren(left, right)
+	assert.NotEqual(t, node, sha256Hasher.HashLeaf(append(append([]byte{}, left...), right...)))
+
+	expected := sha256.Sum256(append(append([]byte{NodePrefix}, left...), right...))
+	assert.Equal(t, expected[:], node)
+}